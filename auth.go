@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Role is a household member's role
+type Role string
+
+const (
+	RoleOwner       Role = "owner"
+	RoleEditor      Role = "editor"
+	RoleContributor Role = "contributor"
+	RoleViewer      Role = "viewer"
+)
+
+func (r Role) valid() bool {
+	switch r {
+	case RoleOwner, RoleEditor, RoleContributor, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// Permission is an action a member may be allowed to perform
+type Permission string
+
+const (
+	PermViewTransactions     Permission = "transactions:view"
+	PermAddTransaction       Permission = "transactions:add"
+	PermDeleteOwnTransaction Permission = "transactions:delete-own"
+	PermDeleteAnyTransaction Permission = "transactions:delete-any"
+	PermEditOwnTransaction   Permission = "transactions:edit-own"
+	PermEditAnyTransaction   Permission = "transactions:edit-any"
+	PermViewCategories       Permission = "categories:view"
+	PermManageCategories     Permission = "categories:manage"
+	PermViewAnalytics        Permission = "analytics:view"
+	PermManageBudgets        Permission = "budgets:manage"
+	PermViewMembers          Permission = "members:view"
+	PermManageMembers        Permission = "members:manage"
+	PermManageWebhooks       Permission = "webhooks:manage"
+)
+
+// permissions is the role/permission matrix. Every route in main.go is
+// guarded by exactly one of these permissions.
+var permissions = map[Role]map[Permission]bool{
+	RoleOwner: {
+		PermViewTransactions:     true,
+		PermAddTransaction:       true,
+		PermDeleteOwnTransaction: true,
+		PermDeleteAnyTransaction: true,
+		PermEditOwnTransaction:   true,
+		PermEditAnyTransaction:   true,
+		PermViewCategories:       true,
+		PermManageCategories:     true,
+		PermViewAnalytics:        true,
+		PermManageBudgets:        true,
+		PermViewMembers:          true,
+		PermManageMembers:        true,
+		PermManageWebhooks:       true,
+	},
+	RoleEditor: {
+		PermViewTransactions:     true,
+		PermAddTransaction:       true,
+		PermDeleteOwnTransaction: true,
+		PermDeleteAnyTransaction: true,
+		PermEditOwnTransaction:   true,
+		PermEditAnyTransaction:   true,
+		PermViewCategories:       true,
+		PermManageCategories:     true,
+		PermViewAnalytics:        true,
+		PermManageBudgets:        true,
+		PermViewMembers:          true,
+	},
+	RoleContributor: {
+		PermViewTransactions:     true,
+		PermAddTransaction:       true,
+		PermDeleteOwnTransaction: true,
+		PermEditOwnTransaction:   true,
+		PermViewCategories:       true,
+		PermViewAnalytics:        true,
+		PermViewMembers:          true,
+	},
+	RoleViewer: {
+		PermViewTransactions: true,
+		PermViewCategories:   true,
+		PermViewAnalytics:    true,
+		PermViewMembers:      true,
+	},
+}
+
+func (r Role) can(p Permission) bool {
+	return permissions[r][p]
+}
+
+const memberContextKey = "member"
+
+// authenticate resolves the bearer token on the request to a household member
+func (s *Server) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			respondError(c, unauthorized("missing bearer token"))
+			return
+		}
+
+		m, err := s.store.MemberByTokenHash(c.Request.Context(), hashToken(token))
+		if errors.Is(err, errNotFound) {
+			respondError(c, unauthorized("invalid token"))
+			return
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.Set(memberContextKey, &m)
+		c.Next()
+	}
+}
+
+// require rejects requests from members whose role lacks the permission
+func require(p Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m := currentMember(c)
+		if m == nil || !m.Role.can(p) {
+			respondError(c, forbidden("your role does not allow this action"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentMember returns the authenticated member, or nil outside authenticate
+func currentMember(c *gin.Context) *Member {
+	v, ok := c.Get(memberContextKey)
+	if !ok {
+		return nil
+	}
+	return v.(*Member)
+}
+
+// newToken returns a random token and the hash that is stored for it
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createOwner adds an owner member and returns its API token. Used to
+// bootstrap a household before anyone can send invitations.
+func createOwner(ctx context.Context, store MemberStore, name string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := store.CreateMember(ctx, Member{Name: name, Role: RoleOwner}, hash); err != nil {
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestServer returns a server on a fresh in-memory store, wired up like
+// main does for memory://, and its router
+func newTestServer(t *testing.T) (*Server, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	s := &Server{store: store, cfg: cfg, metrics: newMetrics(store)}
+	s.cache = newMemoryCache(cfg.Cache.LocalMaxEntries)
+	s.events = newEventBroker(nil, cfg.Cache.Timeout, cfg.Events.History)
+	s.webhooks = newWebhookDispatcher(store, cfg.Webhooks, s.metrics)
+	s.outbox = newOutboxRelay(store, cfg.Outbox, s.deliverEvent)
+	s.channels = newNotificationChannels(cfg.Alerts)
+	return s, newRouter(s)
+}
+
+// addMember stores a member with role and returns it with its API token
+func addMember(t *testing.T, s *Server, name string, role Role) (Member, string) {
+	t.Helper()
+	token, hash, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.store.CreateMember(context.Background(), Member{Name: name, Role: role}, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, token
+}
+
+// request sends a JSON request through h as the member with token
+func request(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	return serve(h, newJSONRequest(method, path, token, body))
+}
+
+// newJSONRequest builds a JSON request as the member with token, for tests
+// that need more headers than request sets
+func newJSONRequest(method, path, token, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals the JSON body of rec into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+}
+
+func noEvents[T any](T) []Event { return nil }
+
+// authFixture holds the IDs of the records the role/route table acts on
+type authFixture struct {
+	own, other, category, budget, goal, bystander, invitation, webhook, notification int
+}
+
+// newAuthFixture adds a member with role and one of every record a route
+// can address. own is a transaction of that member, other one of an owner.
+func newAuthFixture(t *testing.T, s *Server, role Role) (authFixture, string) {
+	t.Helper()
+	ctx := context.Background()
+	owner, _ := addMember(t, s, "Owner", RoleOwner)
+	caller, token := addMember(t, s, "Caller", role)
+	bystander, _ := addMember(t, s, "Bystander", RoleViewer)
+
+	f := authFixture{category: 1, bystander: bystander.ID}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	own, err := s.store.CreateTransaction(ctx, Transaction{
+		Date: "2026-10-01", Description: "Lunch", Amount: 12, Type: "expense", CreatedBy: &caller.ID,
+	}, noEvents)
+	must(err)
+	other, err := s.store.CreateTransaction(ctx, Transaction{
+		Date: "2026-10-02", Description: "Rent", Amount: 900, Type: "expense", CreatedBy: &owner.ID,
+	}, noEvents)
+	must(err)
+	budget, err := s.store.CreateBudget(ctx, Budget{
+		CategoryID: 1, Amount: 400, Period: "monthly", StartDate: "2026-10-01", Rollover: rolloverNone,
+	}, noEvents)
+	must(err)
+	tag := "trip"
+	goal, err := s.store.CreateGoal(ctx, Goal{
+		Name: "Trip", TargetAmount: 500, TargetDate: "2030-01-01", StartDate: "2026-10-01", Tag: &tag,
+	}, noEvents)
+	must(err)
+	_, hash, err := newToken()
+	must(err)
+	invitation, err := s.store.CreateInvitation(ctx, Invitation{Role: RoleViewer, InvitedBy: &owner.ID}, hash, time.Now().Add(time.Hour))
+	must(err)
+	webhook, err := s.store.CreateWebhook(ctx, Webhook{
+		URL: "http://127.0.0.1:1/hook", Events: []string{eventTransactionCreated}, Secret: "secret", CreatedBy: &owner.ID,
+	})
+	must(err)
+	threshold := 80
+	notification, err := s.store.CreateBudgetAlert(ctx, Notification{
+		Type: eventBudgetThreshold, Message: "Groceries has used 80% of its monthly budget",
+		BudgetID: &budget.ID, Threshold: &threshold,
+	}, noEvents)
+	must(err)
+
+	f.own, f.other, f.budget, f.goal = own.ID, other.ID, budget.ID, goal.ID
+	f.invitation, f.webhook, f.notification = invitation.ID, webhook.ID, notification.ID
+	return f, token
+}
+
+const (
+	transactionBody = `{"date":"2026-10-03","description":"Coffee","amount":3.5,"type":"expense"}`
+	budgetBody      = `{"category_id":1,"amount":300,"start_date":"2026-10-01"}`
+	goalBody        = `{"name":"Trip","target_amount":800,"target_date":"2030-01-01","tag":"trip"}`
+)
+
+var (
+	allRoles    = []Role{RoleOwner, RoleEditor, RoleContributor, RoleViewer}
+	contributes = []Role{RoleOwner, RoleEditor, RoleContributor}
+	edits       = []Role{RoleOwner, RoleEditor}
+	owns        = []Role{RoleOwner}
+)
+
+// routeCases lists every authenticated route with a request that succeeds
+// for the roles in allowed
+var routeCases = []struct {
+	method, route string
+	path          func(f authFixture) string
+	body          string
+	allowed       []Role
+}{
+	{"GET", "/api/events", fixed("/api/events"), "", allRoles},
+	{"GET", "/api/transactions", fixed("/api/transactions"), "", allRoles},
+	{"POST", "/api/transactions", fixed("/api/transactions"), transactionBody, contributes},
+	{"GET", "/api/transactions/:id", func(f authFixture) string { return fmt.Sprintf("/api/transactions/%d", f.other) }, "", allRoles},
+	{"PUT", "/api/transactions/:id", func(f authFixture) string { return fmt.Sprintf("/api/transactions/%d", f.own) }, transactionBody, contributes},
+	{"PUT", "/api/transactions/:id", func(f authFixture) string { return fmt.Sprintf("/api/transactions/%d", f.other) }, transactionBody, edits},
+	{"DELETE", "/api/transactions/:id", func(f authFixture) string { return fmt.Sprintf("/api/transactions/%d", f.own) }, "", contributes},
+	{"DELETE", "/api/transactions/:id", func(f authFixture) string { return fmt.Sprintf("/api/transactions/%d", f.other) }, "", edits},
+	{"GET", "/api/categories", fixed("/api/categories"), "", allRoles},
+	{"POST", "/api/categories", fixed("/api/categories"), `{"name":"Pets","type":"expense","color":"#123456"}`, edits},
+	{"GET", "/api/categories/:id", func(f authFixture) string { return fmt.Sprintf("/api/categories/%d", f.category) }, "", allRoles},
+	{"PUT", "/api/categories/:id", func(f authFixture) string { return fmt.Sprintf("/api/categories/%d", f.category) }, `{"name":"Food","type":"expense","color":"#e74c3c"}`, edits},
+	{"GET", "/api/analytics", fixed("/api/analytics"), "", allRoles},
+	{"GET", "/api/budgets", fixed("/api/budgets"), "", allRoles},
+	{"POST", "/api/budgets", fixed("/api/budgets"), budgetBody, edits},
+	{"GET", "/api/budgets/ledger", fixed("/api/budgets/ledger"), "", allRoles},
+	{"PUT", "/api/budgets/allocations/:month/:category_id", fixed("/api/budgets/allocations/2026-10/1"), `{"amount":50}`, edits},
+	{"GET", "/api/budgets/:id", func(f authFixture) string { return fmt.Sprintf("/api/budgets/%d", f.budget) }, "", allRoles},
+	{"PUT", "/api/budgets/:id", func(f authFixture) string { return fmt.Sprintf("/api/budgets/%d", f.budget) }, budgetBody, edits},
+	{"DELETE", "/api/budgets/:id", func(f authFixture) string { return fmt.Sprintf("/api/budgets/%d", f.budget) }, "", edits},
+	{"GET", "/api/goals", fixed("/api/goals"), "", allRoles},
+	{"POST", "/api/goals", fixed("/api/goals"), goalBody, edits},
+	{"GET", "/api/goals/:id", func(f authFixture) string { return fmt.Sprintf("/api/goals/%d", f.goal) }, "", allRoles},
+	{"PUT", "/api/goals/:id", func(f authFixture) string { return fmt.Sprintf("/api/goals/%d", f.goal) }, goalBody, edits},
+	{"DELETE", "/api/goals/:id", func(f authFixture) string { return fmt.Sprintf("/api/goals/%d", f.goal) }, "", edits},
+	{"GET", "/api/members", fixed("/api/members"), "", allRoles},
+	{"PUT", "/api/members/:id/role", func(f authFixture) string { return fmt.Sprintf("/api/members/%d/role", f.bystander) }, `{"role":"editor"}`, owns},
+	{"DELETE", "/api/members/:id", func(f authFixture) string { return fmt.Sprintf("/api/members/%d", f.bystander) }, "", owns},
+	{"GET", "/api/invitations", fixed("/api/invitations"), "", owns},
+	{"POST", "/api/invitations", fixed("/api/invitations"), `{"role":"viewer"}`, owns},
+	{"DELETE", "/api/invitations/:id", func(f authFixture) string { return fmt.Sprintf("/api/invitations/%d", f.invitation) }, "", owns},
+	{"GET", "/api/webhooks", fixed("/api/webhooks"), "", owns},
+	{"POST", "/api/webhooks", fixed("/api/webhooks"), `{"url":"https://example.com/hook","events":["transaction.created"]}`, owns},
+	{"GET", "/api/webhooks/:id", func(f authFixture) string { return fmt.Sprintf("/api/webhooks/%d", f.webhook) }, "", owns},
+	{"DELETE", "/api/webhooks/:id", func(f authFixture) string { return fmt.Sprintf("/api/webhooks/%d", f.webhook) }, "", owns},
+	{"GET", "/api/webhooks/:id/deliveries", func(f authFixture) string { return fmt.Sprintf("/api/webhooks/%d/deliveries", f.webhook) }, "", owns},
+	{"POST", "/api/webhooks/:id/test", func(f authFixture) string { return fmt.Sprintf("/api/webhooks/%d/test", f.webhook) }, "", owns},
+	{"GET", "/api/notifications", fixed("/api/notifications"), "", allRoles},
+	{"GET", "/api/notifications/unread_count", fixed("/api/notifications/unread_count"), "", allRoles},
+	{"POST", "/api/notifications/read", fixed("/api/notifications/read"), "", allRoles},
+	{"POST", "/api/notifications/:id/read", func(f authFixture) string { return fmt.Sprintf("/api/notifications/%d/read", f.notification) }, "", allRoles},
+}
+
+func fixed(path string) func(authFixture) string {
+	return func(authFixture) string { return path }
+}
+
+func TestRoutePermissions(t *testing.T) {
+	for _, rc := range routeCases {
+		for _, role := range allRoles {
+			allowed := false
+			for _, r := range rc.allowed {
+				allowed = allowed || r == role
+			}
+			s, h := newTestServer(t)
+			f, token := newAuthFixture(t, s, role)
+			path := rc.path(f)
+
+			t.Run(fmt.Sprintf("%s %s as %s", rc.method, path, role), func(t *testing.T) {
+				req := newJSONRequest(rc.method, path, token, rc.body)
+				// The event stream only ends with its request
+				ctx, cancel := context.WithTimeout(req.Context(), 50*time.Millisecond)
+				defer cancel()
+				rec := serve(h, req.WithContext(ctx))
+
+				switch {
+				case allowed && (rec.Code < 200 || rec.Code > 299):
+					t.Errorf("got %d, want 2xx: %s", rec.Code, rec.Body)
+				case !allowed && rec.Code != http.StatusForbidden:
+					t.Errorf("got %d, want 403: %s", rec.Code, rec.Body)
+				}
+			})
+		}
+	}
+}
+
+// TestRoutePermissionsCoverEveryRoute keeps routeCases in step with newRouter
+func TestRoutePermissionsCoverEveryRoute(t *testing.T) {
+	_, h := newTestServer(t)
+	covered := map[string]bool{}
+	for _, rc := range routeCases {
+		covered[rc.method+" "+rc.route] = true
+	}
+	for _, r := range h.Routes() {
+		unauthenticated := r.Path == "/api/invitations/accept" || !strings.HasPrefix(r.Path, "/api/")
+		if !unauthenticated && !covered[r.Method+" "+r.Path] {
+			t.Errorf("%s %s has no role/route case", r.Method, r.Path)
+		}
+	}
+}
+
+func TestUnauthenticatedRequestsAreRejected(t *testing.T) {
+	_, h := newTestServer(t)
+	if rec := request(h, "GET", "/api/transactions", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token got %d, want 401", rec.Code)
+	}
+	if rec := request(h, "GET", "/api/transactions", "not-a-token", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("with an unknown token got %d, want 401", rec.Code)
+	}
+}
+
+func TestQueryTokenIsTakenOutOfTheURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var seen *http.Request
+	r := gin.New()
+	r.Use(queryToken(), func(c *gin.Context) { seen = c.Request.Clone(c) })
+	r.GET("/api/events", func(c *gin.Context) {})
+
+	serve(r, httptest.NewRequest("GET", "/api/events?types=transaction&access_token=secret", nil))
+	if got := seen.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the query token", got)
+	}
+	for _, u := range []string{seen.URL.String(), seen.RequestURI} {
+		if strings.Contains(u, "secret") || !strings.Contains(u, "types=transaction") {
+			t.Errorf("URL %q still has the token or lost the other parameters", u)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/events?access_token=secret", nil)
+	req.Header.Set("Authorization", "Bearer header")
+	serve(r, req)
+	if got := seen.Header.Get("Authorization"); got != "Bearer header" || seen.URL.RawQuery != "" {
+		t.Errorf("with a header got Authorization %q and query %q, want the header and no token", got, seen.URL.RawQuery)
+	}
+}
+
+func TestInvitationCanOnlyBeAcceptedOnce(t *testing.T) {
+	s, h := newTestServer(t)
+	_, owner := addMember(t, s, "Owner", RoleOwner)
+
+	rec := request(h, "POST", "/api/invitations", owner, `{"role":"contributor"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating the invitation got %d: %s", rec.Code, rec.Body)
+	}
+	var inv Invitation
+	decode(t, rec, &inv)
+
+	accept := fmt.Sprintf(`{"token":%q,"name":"Sam"}`, inv.Token)
+	rec = request(h, "POST", "/api/invitations/accept", "", accept)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("first accept got %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var accepted struct {
+		Member Member `json:"member"`
+		Token  string `json:"token"`
+	}
+	decode(t, rec, &accepted)
+	if accepted.Member.Role != RoleContributor {
+		t.Errorf("member has role %q, want contributor", accepted.Member.Role)
+	}
+	if rec := request(h, "GET", "/api/transactions", accepted.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("the new member's token got %d, want 200", rec.Code)
+	}
+
+	rec = request(h, "POST", "/api/invitations/accept", "", accept)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second accept got %d, want 404: %s", rec.Code, rec.Body)
+	}
+	members, err := s.store.ListMembers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Errorf("got %d members, want 2", len(members))
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listBudgets retrieves every budget, including those replaced by a budget
+// with a later start date
+func (s *Server) listBudgets(c *gin.Context) {
+	budgets, err := s.store.ListBudgets(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, budgets)
+}
+
+// getBudget retrieves one budget with its ETag
+func (s *Server) getBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid budget id"))
+		return
+	}
+
+	b, err := s.store.GetBudget(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if notModified(c, versionETag(b.Version)) {
+		return
+	}
+
+	c.JSON(http.StatusOK, b)
+}
+
+// createBudget adds a budget, in effect from the month of its start date
+func (s *Server) createBudget(c *gin.Context) {
+	var b Budget
+	if err := c.ShouldBindJSON(&b); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := s.checkEnvelopeCategory(ctx, b.CategoryID); err != nil {
+		respondError(c, err)
+		return
+	}
+	setBudgetDefaults(&b)
+	result, err := s.store.CreateBudget(ctx, b, func(b Budget) []Event {
+		return []Event{newEvent(c, eventBudgetUpdated, fmt.Sprintf("budget:%d", b.ID), PermViewAnalytics, b)}
+	})
+	if err != nil {
+		respondError(c, categoryReferenceError(err))
+		return
+	}
+
+	s.outbox.nudge()
+	s.evaluateBudget(ctx, &result.CategoryID, currentMonth())
+
+	c.Header("ETag", versionETag(result.Version))
+	c.JSON(http.StatusCreated, result)
+}
+
+// updateBudget replaces a budget
+func (s *Server) updateBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid budget id"))
+		return
+	}
+	var b Budget
+	if err := c.ShouldBindJSON(&b); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	current, err := s.store.GetBudget(ctx, id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	expected, ok := s.ifMatch(c, current.Version)
+	if !ok {
+		return
+	}
+	if err := s.checkEnvelopeCategory(ctx, b.CategoryID); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	b.ID = id
+	setBudgetDefaults(&b)
+	result, err := s.store.UpdateBudget(ctx, b, expected, func(b Budget) []Event {
+		return []Event{newEvent(c, eventBudgetUpdated, fmt.Sprintf("budget:%d", b.ID), PermViewAnalytics, b)}
+	})
+	if err != nil {
+		respondError(c, categoryReferenceError(err))
+		return
+	}
+
+	s.outbox.nudge()
+	s.evaluateBudget(ctx, &result.CategoryID, currentMonth())
+
+	c.Header("ETag", versionETag(result.Version))
+	c.JSON(http.StatusOK, result)
+}
+
+// deleteBudget removes a budget by ID, along with its alerts
+func (s *Server) deleteBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid budget id"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	b, err := s.store.GetBudget(ctx, id)
+	if err != nil && !errors.Is(err, errNotFound) {
+		respondError(c, err)
+		return
+	}
+	var expected int
+	if err == nil {
+		var ok bool
+		if expected, ok = s.ifMatch(c, b.Version); !ok {
+			return
+		}
+	} else if c.GetHeader("If-Match") != "" {
+		// A precondition can never hold for a budget that is gone
+		respondError(c, errVersionMismatch)
+		return
+	}
+
+	events := []Event{newEvent(c, eventBudgetDeleted, fmt.Sprintf("budget:%d", id), PermViewAnalytics, gin.H{"id": id})}
+	if err := s.store.DeleteBudget(ctx, id, expected, events); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	s.outbox.nudge()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Budget deleted"})
+}
+
+// setBudgetDefaults fills in the optional fields of a budget
+func setBudgetDefaults(b *Budget) {
+	if b.Period == "" {
+		b.Period = "monthly"
+	}
+	if b.Rollover == "" {
+		b.Rollover = rolloverNone
+	}
+}
+
+// checkEnvelopeCategory reports a field error unless categoryID is an
+// expense category; only spending can be budgeted or assigned money
+func (s *Server) checkEnvelopeCategory(ctx context.Context, categoryID int) error {
+	cat, err := s.store.GetCategory(ctx, categoryID)
+	if errors.Is(err, errNotFound) {
+		return invalidField("category_id", "category does not exist")
+	}
+	if err != nil {
+		return err
+	}
+	if cat.Type != "expense" {
+		return invalidField("category_id", "must be an expense category")
+	}
+	return nil
+}
+
+// evaluateBudget alerts on the budget of a category in month after a write
+// changed its budget or added to its spending
+func (s *Server) evaluateBudget(ctx context.Context, categoryID *int, month string) {
+	if categoryID == nil || len(s.cfg.Alerts.Thresholds) == 0 {
+		return
+	}
+	usage, err := s.store.BudgetUsage(ctx, *categoryID, month)
+	if errors.Is(err, errNotFound) {
+		return
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Checking budget failed", "category_id", *categoryID, "error", err)
+		return
+	}
+	s.alertBudgets(ctx, month, []BudgetUsage{usage})
+}
+
+// evaluateBudgets alerts on every budget every interval. This catches
+// spending that was not written through the API, and alerts whose
+// evaluation after a write was lost.
+func (s *Server) evaluateBudgets(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if len(s.cfg.Alerts.Thresholds) == 0 {
+				continue
+			}
+			month := currentMonth()
+			usage, err := s.store.ListBudgetUsage(ctx, month)
+			if err != nil {
+				slog.Warn("Evaluating budgets failed", "error", err)
+				continue
+			}
+			s.alertBudgets(ctx, month, usage)
+		}
+	}
+}
+
+// alertBudgets alerts on each of usage in month. Thresholds are measured
+// against what the envelope has available, so the balance carried in from
+// earlier months counts as in the ledger: unspent money carried in raises
+// the limit and overspending carried in lowers it.
+func (s *Server) alertBudgets(ctx context.Context, month string, usage []BudgetUsage) {
+	ledger, err := s.loadLedger(ctx, month, month, 0)
+	if err != nil {
+		slog.WarnContext(ctx, "Reading carried-in budget balances failed", "error", err)
+		return
+	}
+	carried := map[int]float64{}
+	for _, m := range ledger.Months {
+		for _, e := range m.Envelopes {
+			carried[e.CategoryID] = e.CarriedIn
+		}
+	}
+	for _, u := range usage {
+		u.CarriedIn = carried[u.CategoryID]
+		s.alertBudget(ctx, month, u)
+	}
+}
+
+// alertBudget stores a notification when u has reached one of the alert
+// thresholds. Only the highest threshold reached is alerted, once per
+// period; crossing 80% and 100% in one write alerts 100% alone.
+func (s *Server) alertBudget(ctx context.Context, month string, u BudgetUsage) {
+	threshold := reachedThreshold(u, s.cfg.Alerts.Thresholds)
+	if threshold == 0 {
+		return
+	}
+	available := u.available()
+	typ := eventBudgetThreshold
+	message := fmt.Sprintf("%s has used %d%% of its %s budget: %.2f of %.2f",
+		u.CategoryName, percentOf(u.Spent, available), u.Period, u.Spent, available)
+	if threshold >= 100 {
+		typ = eventBudgetExceeded
+		message = fmt.Sprintf("%s is over its %s budget: %.2f of %.2f", u.CategoryName, u.Period, u.Spent, available)
+	}
+	periodStart := month + "-01"
+	n := Notification{
+		Type: typ, Message: message, BudgetID: &u.BudgetID, CategoryID: &u.CategoryID,
+		Threshold: &threshold, Amount: &available, Spent: &u.Spent, PeriodStart: &periodStart,
+	}
+
+	_, err := s.store.CreateBudgetAlert(ctx, n, func(n Notification) []Event {
+		return []Event{systemEvent(n.Type, fmt.Sprintf("budget:%d", u.BudgetID), PermViewAnalytics, n)}
+	})
+	if errors.Is(err, errConflict) {
+		return
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Storing budget alert failed", "budget_id", u.BudgetID, "error", err)
+		return
+	}
+	s.outbox.nudge()
+}
+
+// reachedThreshold returns the highest of thresholds that u's spending has
+// reached of what is available, or 0. An envelope that starts the month with
+// nothing available does not alert; it did when it was overspent.
+func reachedThreshold(u BudgetUsage, thresholds []int) int {
+	available := u.available()
+	if available <= 0 {
+		return 0
+	}
+	reached := 0
+	for _, t := range thresholds {
+		if u.Spent*100 >= float64(t)*available && t > reached {
+			reached = t
+		}
+	}
+	return reached
+}
+
+func percentOf(spent, amount float64) int {
+	return int(spent * 100 / amount)
+}
+
+// notificationFromEvent returns the notification carried by a budget alert
+// event
+func notificationFromEvent(e Event) (Notification, bool) {
+	if e.Type != eventBudgetThreshold && e.Type != eventBudgetExceeded {
+		return Notification{}, false
+	}
+	var n Notification
+	if err := json.Unmarshal(e.Data, &n); err != nil {
+		return Notification{}, false
+	}
+	return n, true
+}
+
+// budgetSpending is what t counts towards the budget of its category in
+// month, matching the store's BudgetUsage
+func budgetSpending(t Transaction, month string) float64 {
+	if t.Type != "expense" || len(t.Date) < len(month) || t.Date[:len(month)] != month {
+		return 0
+	}
+	return t.Amount
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache is a best-effort byte cache. Failures behave like misses so that
+// requests are always served from the store when the cache is unhealthy.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+	Delete(ctx context.Context, keys ...string)
+	// Incr atomically adds one to the counter under key, starting from zero
+	Incr(ctx context.Context, key string) (int64, error)
+	// Counter reads the counter under key; one never incremented is at
+	// zero. Unlike Get it tells a missing key from a cache that cannot be
+	// reached.
+	Counter(ctx context.Context, key string) (int64, error)
+	// Lock takes a lock that expires after ttl unless released with unlock.
+	// It reports false only when someone else holds the lock; if the cache
+	// cannot be reached the caller goes ahead as if it held it.
+	Lock(ctx context.Context, key string, ttl time.Duration) (unlock func(), ok bool)
+	Ping(ctx context.Context) error
+	Stats() cacheStats
+}
+
+// cacheStats describes the cache in /readyz
+type cacheStats struct {
+	// Mode is "memory", "redis" or "memory+redis"
+	Mode string `json:"mode"`
+	// Circuit is the state of the Redis circuit breaker: closed while Redis
+	// is used, open while it is skipped
+	Circuit       string `json:"circuit,omitempty"`
+	LocalEntries  int    `json:"local_entries"`
+	LocalCapacity int    `json:"local_capacity"`
+}
+
+// redisCache implements Cache with Redis. Each command gets at most timeout,
+// so a hung Redis shows up as misses rather than stalled requests, and the
+// breaker stops sending commands at all once Redis keeps failing.
+type redisCache struct {
+	client  *redis.Client
+	timeout time.Duration
+	breaker *breaker
+}
+
+// errCircuitOpen is returned instead of calling Redis while the breaker is open
+var errCircuitOpen = errors.New("redis circuit breaker is open")
+
+// newRedisCache wraps client. When Redis does not answer at startup the
+// breaker starts open, and the reconnect loop closes it once Redis is up.
+func newRedisCache(client *redis.Client, cfg CacheConfig) *redisCache {
+	c := &redisCache{client: client, timeout: cfg.Timeout, breaker: newBreaker(cfg.BreakerThreshold)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Ping(ctx); err != nil {
+		slog.Warn("Redis is unavailable; caching in process until it comes up", "error", err)
+		c.breaker.trip()
+	}
+	return c
+}
+
+// do runs one command through the breaker. A missing key is an answer, not
+// a failure.
+func (c *redisCache) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !c.breaker.allow() {
+		return errCircuitOpen
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	err := fn(ctx)
+	c.breaker.record(err == nil || errors.Is(err, redis.Nil))
+	return err
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	var data []byte
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		data, err = c.client.Get(ctx, key).Bytes()
+		return err
+	})
+	return data, err == nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	c.do(ctx, func(ctx context.Context) error {
+		return c.client.SetEx(ctx, key, value, ttl).Err()
+	})
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) {
+	c.do(ctx, func(ctx context.Context) error {
+		return c.client.Del(ctx, keys...).Err()
+	})
+}
+
+func (c *redisCache) Incr(ctx context.Context, key string) (int64, error) {
+	var n int64
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		n, err = c.client.Incr(ctx, key).Result()
+		return err
+	})
+	return n, err
+}
+
+func (c *redisCache) Counter(ctx context.Context, key string) (int64, error) {
+	var n int64
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		n, err = c.client.Get(ctx, key).Int64()
+		return err
+	})
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// unlockScript deletes a lock only while it still holds the caller's token,
+// so a holder that overran the TTL cannot release someone else's lock
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (c *redisCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool) {
+	token := newRequestID()
+	var acquired bool
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		acquired, err = c.client.SetNX(ctx, key, token, ttl).Result()
+		return err
+	})
+	if err != nil {
+		return func() {}, true
+	}
+	if !acquired {
+		return nil, false
+	}
+	return func() {
+		c.do(context.WithoutCancel(ctx), func(ctx context.Context) error {
+			return unlockScript.Run(ctx, c.client, []string{key}, token).Err()
+		})
+	}, true
+}
+
+// Ping bypasses the breaker so that health checks and the reconnect loop
+// see the real state of Redis
+func (c *redisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *redisCache) Stats() cacheStats {
+	return cacheStats{Mode: "redis", Circuit: c.breaker.state()}
+}
+
+// memoryCache implements Cache in process memory. It holds at most
+// maxEntries entries and evicts the least recently used one beyond that.
+// Counters are kept apart from the LRU until deleted: evicting a version
+// counter would reset it and bring back values cached under old versions.
+type memoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List // of *memoryCacheEntry, most recently used first
+	counters   map[string]int64
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func newMemoryCache(maxEntries int) *memoryCache {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		counters:   map[string]int64{},
+	}
+}
+
+// get returns the live entry under key and marks it as recently used.
+// c.mu must be held.
+func (c *memoryCache) get(key string) (*memoryCacheEntry, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryCacheEntry)
+	if time.Now().After(e.expiresAt) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// set stores an entry, evicting the least recently used ones when the cache
+// is full. c.mu must be held.
+func (c *memoryCache) set(key string, value []byte, expiresAt time.Time) {
+	e := &memoryCacheEntry{key: key, value: value, expiresAt: expiresAt}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n, ok := c.counters[key]; ok {
+		return []byte(strconv.FormatInt(n, 10)), true
+	}
+	e, ok := c.get(key)
+	if !ok {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, time.Now().Add(ttl))
+}
+
+func (c *memoryCache) Delete(ctx context.Context, keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.counters, key)
+		if el, ok := c.entries[key]; ok {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Clear drops every entry
+func (c *memoryCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.counters = map[string]int64{}
+}
+
+func (c *memoryCache) Incr(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return 0, fmt.Errorf("value of %s is not a counter", key)
+	}
+	c.counters[key]++
+	return c.counters[key], nil
+}
+
+func (c *memoryCache) Counter(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.counters[key], nil
+}
+
+func (c *memoryCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.get(key); ok {
+		return nil, false
+	}
+	token := []byte(newRequestID())
+	c.set(key, token, time.Now().Add(ttl))
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if e, ok := c.get(key); ok && bytes.Equal(e.value, token) {
+			c.lru.Remove(c.entries[key])
+			delete(c.entries, key)
+		}
+	}, true
+}
+
+func (c *memoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *memoryCache) Stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return cacheStats{Mode: "memory", LocalEntries: c.lru.Len(), LocalCapacity: c.maxEntries}
+}
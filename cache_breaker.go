@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// Circuit breaker states reported in /readyz
+const (
+	circuitClosed = "closed"
+	circuitOpen   = "open"
+)
+
+// breaker stops calls to Redis after threshold consecutive failures, so a
+// dead Redis costs requests nothing instead of a timeout each. It stays
+// open until the reconnect loop sees Redis answer again.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	open      bool
+}
+
+func newBreaker(threshold int) *breaker {
+	return &breaker{threshold: threshold}
+}
+
+// allow reports whether calls may go through
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.open
+}
+
+// record counts the outcome of a call
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if !b.open && b.failures >= b.threshold {
+		b.open = true
+		slog.Warn("Redis keeps failing; caching in process until it recovers", "failures", b.failures)
+	}
+}
+
+// trip opens the breaker straight away
+func (b *breaker) trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open = true
+}
+
+// reset closes the breaker
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open, b.failures = false, 0
+}
+
+func (b *breaker) state() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return circuitOpen
+	}
+	return circuitClosed
+}
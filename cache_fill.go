@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"time"
+)
+
+// cacheEntry is what cached stores under a key: the encoded value and when
+// it stops being fresh. The cache keeps the entry for cfg.Cache.StaleTTL
+// longer so that an expired value can still be served while it is refreshed.
+type cacheEntry struct {
+	FreshUntil time.Time       `json:"fresh_until"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// fillPollInterval is how often a request waiting for another replica's fill
+// looks for the result
+const fillPollInterval = 50 * time.Millisecond
+
+// cached returns the JSON encoding of the value under key, computing it with
+// load on a miss. Concurrent misses in this process share one load, and a
+// lock in the cache makes other replicas wait for that load instead of all
+// querying the database at once. A value older than ttl is still returned
+// for cfg.Cache.StaleTTL while a single background load refreshes it. An
+// empty key, from cacheKey failing to read a version, bypasses the cache.
+func (s *Server) cached(ctx context.Context, key string, ttl time.Duration, load func(context.Context) (any, error)) ([]byte, error) {
+	if s.cache == nil || key == "" {
+		v, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	}
+
+	if e, ok := s.cacheEntry(ctx, key); ok {
+		if time.Now().Before(e.FreshUntil) {
+			return e.Data, nil
+		}
+		s.flights.DoChan(key, func() (any, error) {
+			return s.fill(ctx, key, ttl, load, e.Data)
+		})
+		return e.Data, nil
+	}
+
+	ch := s.flights.DoChan(key, func() (any, error) {
+		return s.fill(ctx, key, ttl, load, nil)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fill loads the value for key and stores it. Only the holder of the key's
+// lock loads. When another replica holds it, a refresh of a stale value
+// leaves the work to that replica and returns the stale value; a fill after
+// a miss waits up to cfg.Cache.LockWait for the other replica's result before
+// loading anyway.
+func (s *Server) fill(ctx context.Context, key string, ttl time.Duration, load func(context.Context) (any, error), stale []byte) ([]byte, error) {
+	// Every request waiting on this key shares the result, so the load must
+	// not fail because the request that started it went away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.cfg.Cache.LockTTL)
+	defer cancel()
+
+	if unlock, ok := s.cache.Lock(ctx, "lock:"+key, s.cfg.Cache.LockTTL); ok {
+		defer unlock()
+		// The previous holder may have filled the key just before we got in
+		if e, ok := s.cacheEntry(ctx, key); ok && time.Now().Before(e.FreshUntil) {
+			return e.Data, nil
+		}
+	} else if stale != nil {
+		return stale, nil
+	} else if data, ok := s.awaitFill(ctx, key); ok {
+		return data, nil
+	}
+
+	v, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fresh := s.jitter(ttl)
+	if entry, err := json.Marshal(cacheEntry{FreshUntil: time.Now().Add(fresh), Data: data}); err == nil {
+		s.cache.Set(ctx, key, entry, fresh+s.cfg.Cache.StaleTTL)
+	}
+	return data, nil
+}
+
+// awaitFill polls for a fresh value under key until cfg.Cache.LockWait passes
+func (s *Server) awaitFill(ctx context.Context, key string) ([]byte, bool) {
+	deadline := time.NewTimer(s.cfg.Cache.LockWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(fillPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-deadline.C:
+			return nil, false
+		case <-ticker.C:
+			if e, ok := s.cacheEntry(ctx, key); ok && time.Now().Before(e.FreshUntil) {
+				return e.Data, true
+			}
+		}
+	}
+}
+
+// cacheEntry reads and decodes the entry under key
+func (s *Server) cacheEntry(ctx context.Context, key string) (cacheEntry, bool) {
+	var e cacheEntry
+	raw, ok := s.cache.Get(ctx, key)
+	if !ok || json.Unmarshal(raw, &e) != nil {
+		return e, false
+	}
+	return e, true
+}
+
+// jitter moves ttl by up to cfg.Cache.TTLJitter percent either way, so keys
+// filled at the same moment do not all expire at the same moment
+func (s *Server) jitter(ttl time.Duration) time.Duration {
+	spread := int64(ttl) * int64(s.cfg.Cache.TTLJitter) / 100
+	if spread <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int64N(2*spread+1)-spread)
+}
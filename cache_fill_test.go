@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingLoader returns a loader that counts its calls and blocks until
+// release is closed
+func countingLoader(calls *atomic.Int32, release <-chan struct{}, v any) func(context.Context) (any, error) {
+	return func(ctx context.Context) (any, error) {
+		calls.Add(1)
+		<-release
+		return v, nil
+	}
+}
+
+func TestCachedConcurrentMissesLoadOnce(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := countingLoader(&calls, release, "value")
+
+	const n = 50
+	var wg sync.WaitGroup
+	results := make([][]byte, n)
+	errs := make([]error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = s.cached(ctx, "test:miss", time.Minute, load)
+		}()
+	}
+	// Let the requests pile up on the load before it finishes; any that
+	// arrive later find the value in the cache
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("loader ran %d times, want 1", got)
+	}
+	for i := range n {
+		if errs[i] != nil || string(results[i]) != `"value"` {
+			t.Fatalf("request %d got %s, %v; want \"value\"", i, results[i], errs[i])
+		}
+	}
+}
+
+func TestCachedServesStaleWhileRefreshing(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+	entry, _ := json.Marshal(cacheEntry{FreshUntil: time.Now().Add(-time.Second), Data: json.RawMessage(`"old"`)})
+	s.cache.Set(ctx, "test:stale", entry, time.Minute)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := countingLoader(&calls, release, "new")
+
+	// The refresh is blocked in the loader, yet every request is answered
+	// with the stale value straight away
+	for range 3 {
+		data, err := s.cached(ctx, "test:stale", time.Minute, load)
+		if err != nil || string(data) != `"old"` {
+			t.Fatalf("got %s, %v; want the stale value", data, err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("loader ran %d times during the refresh, want 1", got)
+	}
+
+	close(release)
+	for time.Now().Before(deadline) {
+		if e, ok := s.cacheEntry(ctx, "test:stale"); ok && string(e.Data) == `"new"` {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	data, err := s.cached(ctx, "test:stale", time.Minute, load)
+	if err != nil || string(data) != `"new"` {
+		t.Errorf("after the refresh got %s, %v; want the new value", data, err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("loader ran %d times, want 1", got)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// invalidationChannel carries the keys that replicas must drop from their
+// in-process tier
+const invalidationChannel = "cache:invalidate"
+
+// invalidation is a message on invalidationChannel. The sender ignores its
+// own messages; it has already updated its local tier.
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys"`
+}
+
+// publishInvalidation tells the other replicas to drop keys from their
+// in-process tier
+func (c *tieredCache) publishInvalidation(ctx context.Context, keys ...string) {
+	payload, err := json.Marshal(invalidation{Origin: c.id, Keys: keys})
+	if err != nil {
+		return
+	}
+	err = c.remote.do(ctx, func(ctx context.Context) error {
+		return c.remote.client.Publish(ctx, invalidationChannel, payload).Err()
+	})
+	if err != nil && !errors.Is(err, errCircuitOpen) {
+		slog.WarnContext(ctx, "Publishing cache invalidation failed", "keys", keys, "error", err)
+	}
+}
+
+// subscribeInvalidations evicts local entries named on the invalidation
+// channel until ctx ends. Invalidations sent while the subscription was down
+// are lost, so the local tier is flushed every time it (re)subscribes.
+func (c *tieredCache) subscribeInvalidations(ctx context.Context, maxBackoff time.Duration) {
+	subscription{
+		client:     c.remote.client,
+		channel:    invalidationChannel,
+		timeout:    c.remote.timeout,
+		maxBackoff: maxBackoff,
+		onState: func(up bool) {
+			if up {
+				c.local.Clear()
+			}
+		},
+		onMessage: func(payload string) {
+			var inv invalidation
+			if json.Unmarshal([]byte(payload), &inv) == nil && inv.Origin != c.id {
+				c.local.Delete(ctx, inv.Keys...)
+			}
+		},
+	}.run(ctx)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryCacheKeepsCountersWhenFull(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache(2)
+	if _, err := c.Incr(ctx, "version:transactions"); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		c.Set(ctx, "value:"+strconv.Itoa(i), []byte("x"), time.Minute)
+	}
+	if n, err := c.Counter(ctx, "version:transactions"); err != nil || n != 1 {
+		t.Errorf("counter after filling the cache = %d, %v; want 1", n, err)
+	}
+	if got := c.Stats().LocalEntries; got != 2 {
+		t.Errorf("local entries = %d, want 2", got)
+	}
+}
+
+// unreachableCache is a cache whose counters cannot be read
+type unreachableCache struct{ Cache }
+
+func (unreachableCache) Counter(ctx context.Context, key string) (int64, error) {
+	return 0, errors.New("redis circuit breaker is open")
+}
+
+func TestCachedBypassesCacheWhenVersionUnreadable(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+	// A value cached before any write, which a guessed version zero would
+	// serve again
+	s.cache.Set(ctx, "transactions:transactions=0", []byte(`{"data":"old"}`), time.Minute)
+	s.cache = unreachableCache{s.cache}
+
+	key := s.cacheKey(ctx, "transactions", nsTransactions)
+	if key != "" {
+		t.Fatalf("key = %q, want none", key)
+	}
+	calls := 0
+	for range 2 {
+		data, err := s.cached(ctx, key, time.Minute, func(ctx context.Context) (any, error) {
+			calls++
+			return "new", nil
+		})
+		if err != nil || string(data) != `"new"` {
+			t.Fatalf("got %s, %v; want the loaded value", data, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("loader ran %d times, want every time", calls)
+	}
+}
+
+// newDownTieredCache returns a tiered cache whose Redis is down and whose
+// breaker has opened
+func newDownTieredCache() *tieredCache {
+	remote := &redisCache{
+		client:  redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}),
+		timeout: 50 * time.Millisecond,
+		breaker: newBreaker(1),
+	}
+	remote.breaker.trip()
+	return newTieredCache(newMemoryCache(100), remote, time.Minute)
+}
+
+func TestTieredCacheKeepsCachingWhileRedisIsDown(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+	s.cache = newDownTieredCache()
+
+	calls := 0
+	load := func(ctx context.Context) (any, error) {
+		calls++
+		return calls, nil
+	}
+	get := func() string {
+		t.Helper()
+		key := s.cacheKey(ctx, "transactions", nsTransactions, nsCategories)
+		if key == "" {
+			t.Fatal("no cache key while Redis is down")
+		}
+		data, err := s.cached(ctx, key, time.Minute, load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if first, second := get(), get(); first != "1" || second != "1" || calls != 1 {
+		t.Errorf("got %s then %s after %d loads, want the first load cached", first, second, calls)
+	}
+	// A write during the outage moves readers to a new key
+	s.bumpVersion(ctx, nsTransactions)
+	if got := get(); got != "2" {
+		t.Errorf("after a write got %s, want a fresh load", got)
+	}
+	if got := get(); got != "2" || calls != 2 {
+		t.Errorf("got %s after %d loads, want the new value cached", got, calls)
+	}
+}
+
+func TestTieredCacheOutageVersionsNeverRepeat(t *testing.T) {
+	ctx := context.Background()
+	c := newDownTieredCache()
+	first, err := c.Counter(ctx, versionKey(nsTransactions))
+	if err != nil || first >= 0 {
+		t.Fatalf("counter while Redis is down = %d, %v; want a negative outage version", first, err)
+	}
+	c.Incr(ctx, versionKey(nsTransactions))
+	second, _ := c.Counter(ctx, versionKey(nsTransactions))
+	// What the reconnect loop does once Redis answers again
+	c.mu.Lock()
+	c.inOutage = false
+	c.mu.Unlock()
+	third, _ := c.Counter(ctx, versionKey(nsTransactions))
+	if !(first > second && second > third) {
+		t.Errorf("outage versions %d, %d, %d; want each lower than the last", first, second, third)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// minReconnectDelay is the first wait between Redis probes while the
+// breaker is open; it doubles up to cfg.Cache.ReconnectMaxBackoff
+const minReconnectDelay = time.Second
+
+// tieredCache puts a bounded in-process LRU in front of Redis. Hot keys are
+// served without a round trip, and while Redis is down the local tier keeps
+// caching on its own. Changed keys are evicted from every replica's local
+// tier over pub/sub; localTTL bounds how long a replica can miss a change
+// when an invalidation message is lost.
+type tieredCache struct {
+	local    *memoryCache
+	remote   *redisCache
+	localTTL time.Duration
+	id       string // tells this replica's invalidations from the others'
+
+	mu     sync.Mutex
+	missed map[string]bool // counters whose Incr did not reach Redis
+	// outage is the version of every counter while Redis cannot be read.
+	// It is negative, so values cached meanwhile never share a key with
+	// one cached under a version from Redis, and it moves down when an
+	// outage starts and with every write during one.
+	outage   int64
+	inOutage bool
+}
+
+func newTieredCache(local *memoryCache, remote *redisCache, localTTL time.Duration) *tieredCache {
+	return &tieredCache{local: local, remote: remote, localTTL: localTTL, id: newRequestID(), missed: map[string]bool{}}
+}
+
+func (c *tieredCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	if data, ok := c.local.Get(ctx, key); ok {
+		return data, true
+	}
+	data, ok := c.remote.Get(ctx, key)
+	if ok {
+		c.local.Set(ctx, key, data, c.localTTL)
+	}
+	return data, ok
+}
+
+func (c *tieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	c.remote.Set(ctx, key, value, ttl)
+	c.local.Set(ctx, key, value, min(ttl, c.localTTL))
+	// Other replicas may hold the previous value, e.g. a stale entry that
+	// was just refreshed
+	c.publishInvalidation(ctx, key)
+}
+
+func (c *tieredCache) Delete(ctx context.Context, keys ...string) {
+	c.remote.Delete(ctx, keys...)
+	c.local.Delete(ctx, keys...)
+	c.publishInvalidation(ctx, keys...)
+}
+
+// Incr counts in Redis, the only place replicas share. When Redis cannot be
+// reached the local tier is cleared instead, so this replica stops serving
+// anything cached before the change, and the increment is replayed once
+// Redis is back so that values cached there before it went down are
+// invalidated too.
+func (c *tieredCache) Incr(ctx context.Context, key string) (int64, error) {
+	n, err := c.remote.Incr(ctx, key)
+	if err != nil {
+		c.mu.Lock()
+		c.missed[key] = true
+		c.inOutage = true
+		c.outage--
+		c.mu.Unlock()
+		c.local.Clear()
+		return 0, err
+	}
+	c.local.Delete(ctx, key)
+	c.publishInvalidation(ctx, key)
+	c.replayMissed(ctx)
+	return n, nil
+}
+
+// Counter prefers the local copy of a counter, which a bump on any replica
+// evicts, and otherwise reads Redis. While Redis cannot be read, or misses
+// bumps that are still to be replayed, it answers with the outage version
+// instead, so the local tier keeps caching.
+func (c *tieredCache) Counter(ctx context.Context, key string) (int64, error) {
+	if data, ok := c.local.Get(ctx, key); ok {
+		if n, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	n, err := c.remote.Counter(ctx, key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Until missed bumps are replayed, the versions in Redis still point at
+	// values cached before those writes
+	if err != nil || len(c.missed) > 0 {
+		if !c.inOutage {
+			c.inOutage = true
+			c.outage--
+		}
+		return c.outage, nil
+	}
+	c.inOutage = false
+	c.local.Set(ctx, key, []byte(strconv.FormatInt(n, 10)), c.localTTL)
+	return n, nil
+}
+
+// replayMissed repeats the increments that failed while Redis was down
+func (c *tieredCache) replayMissed(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.missed {
+		if _, err := c.remote.Incr(ctx, key); err != nil {
+			return
+		}
+		c.local.Delete(ctx, key)
+		c.publishInvalidation(ctx, key)
+		delete(c.missed, key)
+	}
+}
+
+func (c *tieredCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool) {
+	return c.remote.Lock(ctx, key, ttl)
+}
+
+func (c *tieredCache) Ping(ctx context.Context) error {
+	return c.remote.Ping(ctx)
+}
+
+func (c *tieredCache) Stats() cacheStats {
+	stats := c.local.Stats()
+	stats.Mode = "memory+redis"
+	stats.Circuit = c.remote.breaker.state()
+	return stats
+}
+
+// reconnect probes Redis while its breaker is open, backing off up to
+// maxBackoff between attempts, and closes the breaker once Redis answers.
+// It returns when ctx ends.
+func (c *tieredCache) reconnect(ctx context.Context, maxBackoff time.Duration) {
+	delay := minReconnectDelay
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if c.remote.breaker.allow() {
+			delay = minReconnectDelay
+		} else if err := c.probe(ctx); err != nil {
+			delay = min(delay*2, maxBackoff)
+			slog.Debug("Redis still unavailable", "error", err, "retry_in", delay.String())
+		} else {
+			c.remote.breaker.reset()
+			slog.Info("Redis is reachable again; resuming the shared cache")
+			c.replayMissed(ctx)
+			// Versions come from Redis again; what was cached under the
+			// outage version is never read again and ages out
+			c.mu.Lock()
+			c.inOutage = false
+			c.mu.Unlock()
+			delay = minReconnectDelay
+		}
+		timer.Reset(delay)
+	}
+}
+
+func (c *tieredCache) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.remote.timeout)
+	defer cancel()
+	return c.remote.Ping(ctx)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Cache namespaces. Every cached value is derived from one or more of them,
+// and every write to the underlying data bumps the namespace's version.
+const (
+	nsTransactions = "transactions"
+	nsCategories   = "categories"
+	nsGoals        = "goals"
+)
+
+// versionKey holds the data version of a namespace
+func versionKey(ns string) string {
+	return "version:" + ns
+}
+
+// cacheKey builds the key of a value derived from namespaces, stamped with
+// their current versions, e.g. "analytics:transactions=4,categories=2".
+// name may carry parameters of its own, e.g. "transactions:limit=100".
+// Bumping any of the versions moves readers to a new key, so every value
+// derived from a namespace is invalidated at once without enumerating keys.
+// Entries under old keys are never read again and age out with their TTL.
+// When a version cannot be read the key is empty and the value is not
+// cached, since guessing a version could serve data from before a write.
+func (s *Server) cacheKey(ctx context.Context, name string, namespaces ...string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for i, ns := range namespaces {
+		if i == 0 {
+			b.WriteByte(':')
+		} else {
+			b.WriteByte(',')
+		}
+		v, err := s.dataVersion(ctx, ns)
+		if err != nil {
+			slog.DebugContext(ctx, "Reading cache version failed; serving uncached",
+				"namespace", ns, "error", err)
+			return ""
+		}
+		b.WriteString(ns)
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatInt(v, 10))
+	}
+	return b.String()
+}
+
+// dataVersion returns the current version of ns. A namespace that was never
+// bumped is at version zero.
+func (s *Server) dataVersion(ctx context.Context, ns string) (int64, error) {
+	if s.cache == nil {
+		return 0, nil
+	}
+	return s.cache.Counter(ctx, versionKey(ns))
+}
+
+// bumpVersion invalidates everything cached from the given namespaces after
+// a write. A fill still running for the old version can only store under
+// the old key, so it cannot put data from before the write back in front of
+// readers.
+func (s *Server) bumpVersion(ctx context.Context, namespaces ...string) {
+	if s.cache == nil {
+		return
+	}
+	for _, ns := range namespaces {
+		if _, err := s.cache.Incr(ctx, versionKey(ns)); err != nil {
+			slog.WarnContext(ctx, "Bumping cache version failed", "namespace", ns, "error", err)
+		}
+	}
+}
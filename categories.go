@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// categoryParents maps every category's ID to its parent's ID
+func categoryParents(categories []Category) map[int]*int {
+	parents := make(map[int]*int, len(categories))
+	for _, c := range categories {
+		parents[c.ID] = c.ParentID
+	}
+	return parents
+}
+
+// categoryPath returns id followed by its ancestors, nearest first. It stops
+// at a category it has seen before, so it ends even on a cycle.
+func categoryPath(id int, parents map[int]*int) []int {
+	path := []int{id}
+	seen := map[int]bool{id: true}
+	for p := parents[id]; p != nil && !seen[*p]; p = parents[*p] {
+		path = append(path, *p)
+		seen[*p] = true
+	}
+	return path
+}
+
+// categoryAt returns the ancestor of id at depth, where the roots are at
+// depth 1, or id itself when it is no deeper than that or depth is 0
+func categoryAt(id, depth int, parents map[int]*int) int {
+	path := categoryPath(id, parents)
+	if depth == 0 || len(path) <= depth {
+		return id
+	}
+	return path[len(path)-depth]
+}
+
+// categoryTree nests categories under their parents, keeping their order
+func categoryTree(categories []Category) []CategoryNode {
+	known := make(map[int]bool, len(categories))
+	for _, c := range categories {
+		known[c.ID] = true
+	}
+	var roots []Category
+	children := map[int][]Category{}
+	for _, c := range categories {
+		if c.ParentID != nil && known[*c.ParentID] {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	var node func(c Category) CategoryNode
+	node = func(c Category) CategoryNode {
+		n := CategoryNode{Category: c, Children: []CategoryNode{}}
+		for _, child := range children[c.ID] {
+			n.Children = append(n.Children, node(child))
+		}
+		return n
+	}
+	tree := make([]CategoryNode, 0, len(roots))
+	for _, c := range roots {
+		tree = append(tree, node(c))
+	}
+	return tree
+}
+
+// maxQueryDepth bounds ?depth=. Every depth is cached under a key of its
+// own, so clients must not be able to ask for arbitrarily many.
+const maxQueryDepth = 10
+
+// queryDepth parses the ?depth= that rolls subcategories up into their
+// ancestors at that level of the category tree; 0 when it is absent
+func queryDepth(c *gin.Context) (int, error) {
+	raw := c.Query("depth")
+	if raw == "" {
+		return 0, nil
+	}
+	depth, err := strconv.Atoi(raw)
+	if err != nil || depth < 1 || depth > maxQueryDepth {
+		return 0, invalidField("depth", fmt.Sprintf("must be between 1 and %d", maxQueryDepth))
+	}
+	return depth, nil
+}
+
+// checkCategoryParent reports a field error when c's new parent or one of
+// c's subcategories has another type than c. A missing parent and cycles are
+// caught by the store, where concurrent moves are serialized.
+func (s *Server) checkCategoryParent(ctx context.Context, c Category) error {
+	categories, err := s.store.ListCategories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range categories {
+		if c.ParentID != nil && other.ID == *c.ParentID && other.Type != c.Type {
+			return invalidField("parent_id", "must be a category of the same type")
+		}
+		if other.ParentID != nil && *other.ParentID == c.ID && other.Type != c.Type {
+			return invalidField("type", "must match the type of the category's subcategories")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactedHidesPasswords(t *testing.T) {
+	tests := []struct {
+		name, database, redis string
+		wantDatabase          string
+		wantRedis             string
+	}{
+		{"redis password only", "", ":secret@redis:6379", "", ":REDACTED@redis:6379"},
+		{"redis user and password", "", "user:secret@redis:6379/0", "", "user:REDACTED@redis:6379/0"},
+		{"redis without credentials", "", "redis:6379", "", "redis:6379"},
+		{"redis that does not parse", "", "user:se%cret@redis:6379", "", "REDACTED@redis:6379"},
+		{"postgres user info", "postgres://app:secret@db:5432/finance", "", "postgres://app:REDACTED@db:5432/finance", ""},
+		{"postgres password parameter", "postgres://db:5432/finance?user=app&password=secret", "",
+			"postgres://db:5432/finance?password=REDACTED&user=app", ""},
+		{"postgres key=value", "host=db user=app password='se cret' dbname=finance", "",
+			"host=db user=app password=REDACTED dbname=finance", ""},
+		{"postgres that does not parse", "postgres://app:p#ss@db/finance", "", "REDACTED@db/finance", ""},
+		{"sqlite", "sqlite:///tmp/finance.db", "", "sqlite:///tmp/finance.db", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.DatabaseURL, cfg.RedisURL = tt.database, tt.redis
+			got := cfg.redacted()
+			if got.DatabaseURL != tt.wantDatabase {
+				t.Errorf("database_url = %q, want %q", got.DatabaseURL, tt.wantDatabase)
+			}
+			if got.RedisURL != tt.wantRedis {
+				t.Errorf("redis_url = %q, want %q", got.RedisURL, tt.wantRedis)
+			}
+			for _, secret := range []string{"secret", "se cret", "se%cret", "p#ss"} {
+				if strings.Contains(got.DatabaseURL+" "+got.RedisURL, secret) {
+					t.Errorf("%q is still printed", secret)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import "fmt"
+
+// dialect captures the SQL differences between the supported databases
+type dialect struct {
+	name string
+	// daysAgoFormat renders the date n days before today
+	daysAgoFormat string
+	// monthStart renders the first day of the current month
+	monthStart string
+	// monthOfFormat renders the YYYY-MM month of a date column
+	monthOfFormat string
+	// lockSQL and unlockSQL serialize migrations across processes; empty
+	// when the database cannot be shared between processes anyway
+	lockSQL   string
+	unlockSQL string
+	// skipLocked lets concurrent workers claim different rows of a queue;
+	// empty when only one process can use the database
+	skipLocked string
+	// lockCategoriesSQL serializes moves in the category tree until the
+	// transaction ends, so that two concurrent moves cannot form a cycle;
+	// empty when writers are serialized anyway
+	lockCategoriesSQL string
+	// lockMembersSQL serializes changes to who owns the household until the
+	// transaction ends; empty when writers are serialized anyway
+	lockMembersSQL string
+}
+
+// categoryTreeLockKey is the Postgres advisory lock held while moving a
+// category
+const categoryTreeLockKey = 727_310_028
+
+// memberOwnersLockKey is the Postgres advisory lock held while demoting or
+// removing a member
+const memberOwnersLockKey = 727_310_029
+
+var postgresDialect = dialect{
+	name:          "postgres",
+	daysAgoFormat: "CURRENT_DATE - INTERVAL '%d days'",
+	monthStart:    "date_trunc('month', CURRENT_DATE)::date",
+	monthOfFormat: "to_char(%s, 'YYYY-MM')",
+	lockSQL:       fmt.Sprintf("SELECT pg_advisory_lock(%d)", migrationLockKey),
+	unlockSQL:     fmt.Sprintf("SELECT pg_advisory_unlock(%d)", migrationLockKey),
+	skipLocked:    "FOR UPDATE SKIP LOCKED",
+
+	lockCategoriesSQL: fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", categoryTreeLockKey),
+	lockMembersSQL:    fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", memberOwnersLockKey),
+}
+
+var sqliteDialect = dialect{
+	name:          "sqlite",
+	daysAgoFormat: "date('now', '-%d days')",
+	monthStart:    "date('now', 'start of month')",
+	monthOfFormat: "strftime('%%Y-%%m', %s)",
+}
+
+func (d dialect) daysAgo(n int) string {
+	return fmt.Sprintf(d.daysAgoFormat, n)
+}
+
+func (d dialect) monthOf(column string) string {
+	return fmt.Sprintf(d.monthOfFormat, column)
+}
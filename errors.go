@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Error codes returned in the envelope. Clients should branch on the code,
+// never on the message.
+const (
+	codeInvalidJSON      = "invalid_json"
+	codeValidationFailed = "validation_failed"
+	codeInvalidReference = "invalid_reference"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeConflict         = "conflict"
+	codeInternal         = "internal_error"
+	codeUnavailable      = "service_unavailable"
+	codeTimeout          = "timeout"
+	codeClientClosed     = "client_closed_request"
+
+	codePreconditionFailed   = "precondition_failed"
+	codePreconditionRequired = "precondition_required"
+
+	codeIdempotencyKeyReused  = "idempotency_key_reused"
+	codeIdempotencyInProgress = "idempotency_key_in_progress"
+)
+
+// statusClientClosedRequest is the non-standard status (from nginx) recorded
+// when the client went away; nobody receives the response
+const statusClientClosedRequest = 499
+
+// Store errors that map to client errors. Stores return them directly or
+// they are recognised from the driver error.
+var (
+	errInvalidReference = errors.New("referenced record does not exist")
+	errConflict         = errors.New("record already exists")
+)
+
+// apiError is the body of every error response:
+//
+//	{"error": {"code": "...", "message": "...", "details": ..., "request_id": "..."}}
+type apiError struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+// fieldError describes one invalid field of a request body
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func badRequest(msg string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: codeValidationFailed, Message: msg}
+}
+
+// invalidField reports a single invalid field
+func invalidField(field, msg string) *apiError {
+	return &apiError{
+		Status:  http.StatusBadRequest,
+		Code:    codeValidationFailed,
+		Message: "request validation failed",
+		Details: []fieldError{{Field: field, Message: msg}},
+	}
+}
+
+func unauthorized(msg string) *apiError {
+	return &apiError{Status: http.StatusUnauthorized, Code: codeUnauthorized, Message: msg}
+}
+
+func forbidden(msg string) *apiError {
+	return &apiError{Status: http.StatusForbidden, Code: codeForbidden, Message: msg}
+}
+
+func notFound(msg string) *apiError {
+	return &apiError{Status: http.StatusNotFound, Code: codeNotFound, Message: msg}
+}
+
+func conflict(msg string) *apiError {
+	return &apiError{Status: http.StatusConflict, Code: codeConflict, Message: msg}
+}
+
+// bindError converts a ShouldBindJSON error into a 400 response
+func bindError(err error) *apiError {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		details := make([]fieldError, len(verrs))
+		for i, fe := range verrs {
+			details[i] = fieldError{Field: fe.Field(), Message: validationMessage(fe)}
+		}
+		return &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    codeValidationFailed,
+			Message: "request validation failed",
+			Details: details,
+		}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return invalidField(typeErr.Field, "must be "+jsonTypeName(typeErr.Type))
+	}
+
+	msg := "request body is not valid JSON"
+	if errors.Is(err, io.EOF) {
+		msg = "request body is empty"
+	}
+	return &apiError{Status: http.StatusBadRequest, Code: codeInvalidJSON, Message: msg}
+}
+
+// jsonTypeName names t the way API clients know it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return "a string"
+	}
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "min":
+		if fe.Kind() == reflect.Slice {
+			return "must have at least " + fe.Param() + " entries"
+		}
+		return "must be at least " + fe.Param() + " characters long"
+	case "http_url":
+		return "must be an http or https URL"
+	case "datetime":
+		return "must be a date formatted as YYYY-MM-DD"
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param() + " characters long"
+	case "required_without":
+		return "is required when " + strings.ToLower(fe.Param()) + " is not set"
+	case "excluded_with":
+		return "must not be set together with " + strings.ToLower(fe.Param())
+	default:
+		return "failed " + fe.Tag() + " validation"
+	}
+}
+
+// useJSONFieldNames makes validation errors name fields as they appear in
+// the JSON body rather than by their Go struct field names
+func useJSONFieldNames() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" || name == "" {
+				return f.Name
+			}
+			return name
+		})
+	}
+}
+
+// toAPIError maps err to its catalog entry. Anything unrecognised becomes a
+// generic 500 so that driver messages never reach clients.
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		e := *apiErr
+		return &e
+	case errors.Is(err, errNotFound):
+		return notFound("resource not found")
+	case errors.Is(err, errInvalidReference), isForeignKeyViolation(err):
+		return &apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    codeInvalidReference,
+			Message: errInvalidReference.Error(),
+		}
+	case errors.Is(err, errVersionMismatch):
+		return &apiError{
+			Status:  http.StatusPreconditionFailed,
+			Code:    codePreconditionFailed,
+			Message: "the resource was changed by someone else; reload it and try again",
+		}
+	case errors.Is(err, errConflict), isUniqueViolation(err):
+		return conflict(errConflict.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return &apiError{
+			Status:  http.StatusGatewayTimeout,
+			Code:    codeTimeout,
+			Message: "the request took too long to process; try again later",
+		}
+	case errors.Is(err, context.Canceled):
+		return &apiError{
+			Status:  statusClientClosedRequest,
+			Code:    codeClientClosed,
+			Message: "the client closed the request",
+		}
+	case isUnavailable(err):
+		return &apiError{
+			Status:  http.StatusServiceUnavailable,
+			Code:    codeUnavailable,
+			Message: "the database is unavailable; try again later",
+		}
+	default:
+		return &apiError{
+			Status:  http.StatusInternalServerError,
+			Code:    codeInternal,
+			Message: "internal server error",
+		}
+	}
+}
+
+// respondError aborts the request with the error envelope. Server errors are
+// logged with the underlying cause, which the client never sees.
+func respondError(c *gin.Context, err error) {
+	ctx := c.Request.Context()
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		// Some drivers (SQLite) report an interrupted query without wrapping
+		// the context error; the context says why it was interrupted.
+		if toAPIError(err).Status >= 500 {
+			err = fmt.Errorf("%w: %w", ctxErr, err)
+		}
+	}
+	e := toAPIError(err)
+	e.RequestID = requestIDFrom(ctx)
+	switch {
+	case e.Status >= 500:
+		slog.ErrorContext(ctx, "request failed", "status", e.Status, "error", err)
+	case e.Status == statusClientClosedRequest:
+		slog.InfoContext(ctx, "client closed request", "error", err)
+	}
+	c.AbortWithStatusJSON(e.Status, gin.H{"error": e})
+}
+
+// isForeignKeyViolation reports whether err is a Postgres or SQLite foreign
+// key violation
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23503" // foreign_key_violation
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+	return false
+}
+
+// isUnavailable reports whether err means the database could not be reached
+// or is too busy to answer
+func isUnavailable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.As(err, &connectErr), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &sqliteErr):
+		return sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY
+	}
+	return false
+}
+
+// isUniqueViolation reports whether err is a Postgres or SQLite unique
+// constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+			sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
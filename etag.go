@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// versionETag formats a row version as a strong ETag
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// bodyETag derives a weak ETag from a response body, for list endpoints that
+// have no single version
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether header, an If-Match or If-None-Match value,
+// lists tag or is "*". With weak comparison W/ prefixes are ignored.
+func etagMatches(header, tag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if weak {
+		tag = strings.TrimPrefix(tag, "W/")
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch checks the If-Match header of a write against the current version
+// of the resource. It returns the version the store must still find when
+// writing, zero for an unconditional write, or false after responding with
+// 428 (strict mode, header missing) or 412 (stale version).
+func (s *Server) ifMatch(c *gin.Context, current int) (int, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		if s.cfg.Concurrency.RequireIfMatch {
+			respondError(c, &apiError{
+				Status:  http.StatusPreconditionRequired,
+				Code:    codePreconditionRequired,
+				Message: "this request must include an If-Match header with the resource's ETag",
+			})
+			return 0, false
+		}
+		return 0, true
+	}
+	if !etagMatches(header, versionETag(current), false) {
+		respondError(c, errVersionMismatch)
+		return 0, false
+	}
+	return current, true
+}
+
+// notModified sets the ETag header and answers 304 when the client's
+// If-None-Match already lists it
+func notModified(c *gin.Context, tag string) bool {
+	c.Header("ETag", tag)
+	if header := c.GetHeader("If-None-Match"); header != "" && etagMatches(header, tag, true) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// respondCachedJSON writes an already encoded JSON body with a body ETag,
+// or 304 when the client has it
+func respondCachedJSON(c *gin.Context, body []byte) {
+	if notModified(c, bodyETag(body)) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
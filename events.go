@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Event types streamed by GET /api/events
+const (
+	eventTransactionCreated = "transaction.created"
+	eventTransactionUpdated = "transaction.updated"
+	eventTransactionDeleted = "transaction.deleted"
+	eventCategoryCreated    = "category.created"
+	eventCategoryUpdated    = "category.updated"
+	eventAnalyticsChanged   = "analytics.changed"
+	eventBudgetThreshold    = "budget.threshold"
+	eventBudgetExceeded     = "budget.exceeded"
+	eventBudgetUpdated      = "budget.updated"
+	eventBudgetDeleted      = "budget.deleted"
+	eventBudgetAssigned     = "budget.assigned"
+	eventGoalCreated        = "goal.created"
+	eventGoalUpdated        = "goal.updated"
+	eventGoalDeleted        = "goal.deleted"
+	// eventReset tells a client that events were lost and it must reload
+	eventReset = "reset"
+)
+
+// eventsChannel is the Redis channel that fans events out to every replica
+const eventsChannel = "events"
+
+// subscriberBuffer is how many events a stream may fall behind before it is
+// dropped. The client then reconnects and resumes with Last-Event-ID.
+const subscriberBuffer = 64
+
+// Event is a change pushed to dashboards
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Aggregate names the record the event is about, e.g. transaction:42;
+	// the events of one aggregate are relayed in order
+	Aggregate string          `json:"aggregate,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	// Permission is what a member needs to receive the event
+	Permission Permission `json:"permission"`
+	// Actor is the member who made the change
+	Actor int       `json:"actor"`
+	Time  time.Time `json:"time"`
+}
+
+// eventBroker fans events out to the open streams of every replica. With
+// Redis, events are published to eventsChannel and delivered when they come
+// back through the subscription, so all replicas see them in the same order
+// and share event IDs. Without Redis, or while it is unreachable, events
+// only reach the streams of the replica that made the change.
+type eventBroker struct {
+	client     *redis.Client // nil without Redis
+	timeout    time.Duration
+	subscribed atomic.Bool
+
+	mu          sync.Mutex
+	history     []Event         // ring buffer of the last len(history) events
+	next        int             // slot of the next event in history
+	size        int             // events in history
+	seen        map[string]bool // IDs of the events in history
+	subscribers map[chan Event]bool
+	closed      bool
+}
+
+func newEventBroker(client *redis.Client, timeout time.Duration, history int) *eventBroker {
+	return &eventBroker{
+		client:      client,
+		timeout:     timeout,
+		history:     make([]Event, history),
+		seen:        map[string]bool{},
+		subscribers: map[chan Event]bool{},
+	}
+}
+
+// publish sends e to every replica
+func (b *eventBroker) publish(ctx context.Context, e Event) {
+	if b.client != nil && b.subscribed.Load() {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), b.timeout)
+		defer cancel()
+		err = b.client.Publish(ctx, eventsChannel, payload).Err()
+		if err == nil {
+			return
+		}
+		slog.WarnContext(ctx, "Publishing event failed; delivering it to this replica only", "type", e.Type, "error", err)
+	}
+	b.dispatch(e)
+}
+
+// dispatch records e and hands it to this replica's streams. A stream whose
+// buffer is full is dropped rather than allowed to hold up the others. An
+// event still in the history is not sent again, which hides most of the
+// duplicates the outbox relay may produce.
+func (b *eventBroker) dispatch(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.seen[e.ID] {
+		return
+	}
+	if len(b.history) > 0 {
+		if b.size == len(b.history) {
+			delete(b.seen, b.history[b.next].ID)
+		}
+		b.seen[e.ID] = true
+		b.history[b.next] = e
+		b.next = (b.next + 1) % len(b.history)
+		b.size = min(b.size+1, len(b.history))
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// errUnknownEventID is returned by subscribe when the event a client wants
+// to resume after is no longer, or never was, in the history
+var errUnknownEventID = errors.New("event is no longer in the history")
+
+// subscribe registers a stream. With lastEventID it also returns the events
+// since that one, and no event can fall between those and the ones sent to
+// the returned channel. The channel is closed when the stream is dropped or
+// the broker closes; cancel must be called once the stream ends.
+func (b *eventBroker) subscribe(lastEventID string) (missed []Event, events <-chan Event, cancel func(), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastEventID != "" {
+		found := false
+		for i := range b.size {
+			e := b.history[(b.next-b.size+i+len(b.history))%len(b.history)]
+			if found {
+				missed = append(missed, e)
+			} else if e.ID == lastEventID {
+				found = true
+			}
+		}
+		if !found {
+			err = errUnknownEventID
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	if b.closed {
+		close(ch)
+		return missed, ch, func() {}, err
+	}
+	b.subscribers[ch] = true
+	return missed, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}, err
+}
+
+// reset forgets the history and tells every stream to reload, after events
+// may have been lost
+func (b *eventBroker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.next, b.size = 0, 0
+	clear(b.seen)
+	for ch := range b.subscribers {
+		select {
+		case ch <- Event{Type: eventReset, Data: json.RawMessage("{}")}:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every stream so that the server can shut down
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// subscribeEvents delivers the events on eventsChannel to this replica's
+// streams until ctx ends. While the subscription is down events are
+// delivered locally; events other replicas publish in that time are lost,
+// so streams are told to reset once it is back.
+func (b *eventBroker) subscribeEvents(ctx context.Context, maxBackoff time.Duration) {
+	var wasUp bool
+	subscription{
+		client:     b.client,
+		channel:    eventsChannel,
+		timeout:    b.timeout,
+		maxBackoff: maxBackoff,
+		onState: func(up bool) {
+			b.subscribed.Store(up)
+			if up && wasUp {
+				b.reset()
+			}
+			wasUp = wasUp || up
+		},
+		onMessage: func(payload string) {
+			var e Event
+			if err := json.Unmarshal([]byte(payload), &e); err != nil {
+				slog.Warn("Ignoring malformed event", "error", err)
+				return
+			}
+			b.dispatch(e)
+		},
+	}.run(ctx)
+}
+
+// newEvent describes a change to aggregate made by the current member
+func newEvent(c *gin.Context, typ, aggregate string, perm Permission, data any) Event {
+	e := systemEvent(typ, aggregate, perm, data)
+	e.Actor = currentMember(c).ID
+	return e
+}
+
+// systemEvent describes a change to aggregate made by the service itself
+func systemEvent(typ, aggregate string, perm Permission, data any) Event {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		raw = json.RawMessage("{}")
+	}
+	return Event{ID: newRequestID(), Type: typ, Aggregate: aggregate, Data: raw, Permission: perm, Time: time.Now().UTC()}
+}
+
+// transactionEvents describes a transaction change, and the change to
+// analytics that comes with it
+func transactionEvents(c *gin.Context, typ string, id int, data any) []Event {
+	return []Event{
+		newEvent(c, typ, fmt.Sprintf("transaction:%d", id), PermViewTransactions, data),
+		newEvent(c, eventAnalyticsChanged, "analytics", PermViewAnalytics, gin.H{}),
+	}
+}
+
+// queryToken lets EventSource clients, which cannot set headers, pass their
+// token to the event stream as ?access_token=. It runs ahead of tracing and
+// logging and moves the token out of the URL so that neither records it.
+func queryToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := c.Request
+		if r.URL.Path != "/api/events" || !r.URL.Query().Has("access_token") {
+			c.Next()
+			return
+		}
+		q := r.URL.Query()
+		if token := q.Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		q.Del("access_token")
+		r.URL.RawQuery = q.Encode()
+		r.RequestURI = r.URL.RequestURI()
+		c.Next()
+	}
+}
+
+// streamEvents streams changes as Server-Sent Events. Members only receive
+// the events their role may see, optionally narrowed with ?types= to event
+// type prefixes, e.g. types=transaction,analytics. A client that reconnects
+// with Last-Event-ID first gets the events it missed, or a reset event when
+// they are no longer known.
+func (s *Server) streamEvents(c *gin.Context) {
+	var types []string
+	if raw := c.Query("types"); raw != "" {
+		types = strings.Split(raw, ",")
+	}
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+
+	missed, events, cancel, err := s.events.subscribe(lastEventID)
+	defer cancel()
+	if errors.Is(err, errUnknownEventID) {
+		missed = []Event{{Type: eventReset, Data: json.RawMessage("{}")}}
+	}
+
+	// Streams outlive the server's write timeout
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(c.Request.Context(), "Cannot lift the write deadline of an event stream", "error", err)
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	member := currentMember(c)
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	send := func(e Event) {
+		if e.Type != eventReset && (!member.Role.can(e.Permission) || !matchesEventType(e.Type, types)) {
+			return
+		}
+		writeEvent(c.Writer, e)
+	}
+	for _, e := range missed {
+		send(e)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(s.cfg.Events.Heartbeat)
+	defer heartbeat.Stop()
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			send(e)
+		case <-heartbeat.C:
+			// Pick up role changes, and end the stream of removed members
+			m, err := s.store.MemberByTokenHash(ctx, hashToken(token))
+			if errors.Is(err, errNotFound) {
+				return
+			}
+			if err == nil {
+				member = &m
+			}
+			fmt.Fprint(c.Writer, ": ping\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+// matchesEventType reports whether typ starts with one of prefixes, or
+// whether there are none
+func matchesEventType(typ string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, p := range prefixes {
+		if p = strings.TrimSpace(p); p != "" && strings.HasPrefix(typ, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEvent writes e in the text/event-stream format
+func writeEvent(w io.Writer, e Event) {
+	if e.ID != "" {
+		fmt.Fprintf(w, "id: %s\n", e.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, e.Data)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// goalTagPattern is what a goal's tag may look like once its # is removed
+var goalTagPattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// listGoals retrieves every goal with its progress, soonest target first
+func (s *Server) listGoals(c *gin.Context) {
+	goals, err := s.goalsWithProgress(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, goals)
+}
+
+// getGoal retrieves one goal with its progress and ETag. Progress moves
+// with transactions rather than the goal's version, so the ETag serves
+// If-Match only.
+func (s *Server) getGoal(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid goal id"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	g, err := s.store.GetGoal(ctx, id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if g, err = s.withProgress(ctx, g); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Header("ETag", versionETag(g.Version))
+	c.JSON(http.StatusOK, g)
+}
+
+// createGoal adds a goal, saving from today unless it has a start date
+func (s *Server) createGoal(c *gin.Context) {
+	var g Goal
+	if err := c.ShouldBindJSON(&g); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+	if g.StartDate == "" {
+		g.StartDate = time.Now().UTC().Format(time.DateOnly)
+	}
+	if err := normalizeGoal(&g); err != nil {
+		respondError(c, err)
+		return
+	}
+	g.CreatedBy = &currentMember(c).ID
+
+	ctx := c.Request.Context()
+	result, err := s.store.CreateGoal(ctx, g, func(g Goal) []Event {
+		return goalEvents(c, eventGoalCreated, g.ID, g)
+	})
+	if err != nil {
+		respondError(c, categoryReferenceError(err))
+		return
+	}
+
+	s.bumpVersion(ctx, nsGoals)
+	s.outbox.nudge()
+
+	s.respondGoal(c, http.StatusCreated, result)
+}
+
+// updateGoal replaces a goal. A goal without a start date keeps the one it
+// had.
+func (s *Server) updateGoal(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid goal id"))
+		return
+	}
+	var g Goal
+	if err := c.ShouldBindJSON(&g); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	current, err := s.store.GetGoal(ctx, id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	expected, ok := s.ifMatch(c, current.Version)
+	if !ok {
+		return
+	}
+	if g.StartDate == "" {
+		g.StartDate = current.StartDate[:10]
+	}
+	if err := normalizeGoal(&g); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	g.ID = id
+	result, err := s.store.UpdateGoal(ctx, g, expected, func(g Goal) []Event {
+		return goalEvents(c, eventGoalUpdated, g.ID, g)
+	})
+	if err != nil {
+		respondError(c, categoryReferenceError(err))
+		return
+	}
+
+	s.bumpVersion(ctx, nsGoals)
+	s.outbox.nudge()
+
+	s.respondGoal(c, http.StatusOK, result)
+}
+
+// deleteGoal removes a goal by ID
+func (s *Server) deleteGoal(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid goal id"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	g, err := s.store.GetGoal(ctx, id)
+	if err != nil && !errors.Is(err, errNotFound) {
+		respondError(c, err)
+		return
+	}
+	var expected int
+	if err == nil {
+		var ok bool
+		if expected, ok = s.ifMatch(c, g.Version); !ok {
+			return
+		}
+	} else if c.GetHeader("If-Match") != "" {
+		// A precondition can never hold for a goal that is gone
+		respondError(c, errVersionMismatch)
+		return
+	}
+
+	if err := s.store.DeleteGoal(ctx, id, expected, goalEvents(c, eventGoalDeleted, id, gin.H{"id": id})); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	s.bumpVersion(ctx, nsGoals)
+	s.outbox.nudge()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Goal deleted"})
+}
+
+// goalEvents announces a goal write; goals are part of analytics
+func goalEvents(c *gin.Context, typ string, id int, data any) []Event {
+	return []Event{
+		newEvent(c, typ, fmt.Sprintf("goal:%d", id), PermViewAnalytics, data),
+		newEvent(c, eventAnalyticsChanged, "analytics", PermViewAnalytics, gin.H{}),
+	}
+}
+
+// respondGoal writes a goal just written, with its progress and ETag
+func (s *Server) respondGoal(c *gin.Context, status int, g Goal) {
+	g, err := s.withProgress(c.Request.Context(), g)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Header("ETag", versionETag(g.Version))
+	c.JSON(status, g)
+}
+
+// normalizeGoal strips the # from a goal's tag and lowercases it, then
+// checks what binding cannot
+func normalizeGoal(g *Goal) error {
+	if g.Tag != nil {
+		tag := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(*g.Tag), "#"))
+		if !goalTagPattern.MatchString(tag) {
+			return invalidField("tag", "must be letters, digits, _ or - with an optional leading #")
+		}
+		g.Tag = &tag
+	}
+	if g.TargetDate <= g.StartDate {
+		return invalidField("target_date", "must be after start_date")
+	}
+	return nil
+}
+
+// goalsWithProgress lists every goal with its progress as of today. What
+// has been saved is totalled for all goals at once.
+func (s *Server) goalsWithProgress(ctx context.Context) ([]Goal, error) {
+	goals, err := s.store.ListGoals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	saved, err := s.store.GoalsSaved(ctx, today, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := range goals {
+		goals[i].Progress = goalProgress(goals[i], saved[goals[i].ID], today)
+	}
+	return goals, nil
+}
+
+// withProgress fills in g's progress as of today
+func (s *Server) withProgress(ctx context.Context, g Goal) (Goal, error) {
+	today := time.Now().UTC().Format(time.DateOnly)
+	saved, err := s.store.GoalsSaved(ctx, today, g.ID)
+	if err != nil {
+		return Goal{}, err
+	}
+	g.Progress = goalProgress(g, saved[g.ID], today)
+	return g, nil
+}
+
+// goalProgress compares saved with the target of g and with what saving
+// steadily from its start date would have put aside by today. Once the
+// target date has passed the whole remainder is due this month. saved is
+// negative when more was spent than put aside.
+func goalProgress(g Goal, saved float64, today string) GoalProgress {
+	p := GoalProgress{
+		Saved:     roundCents(saved),
+		Remaining: roundCents(max(g.TargetAmount-saved, 0)),
+		Percent:   min(max(percentOf(saved, g.TargetAmount), 0), 100),
+	}
+
+	start, _ := time.Parse(time.DateOnly, g.StartDate[:10])
+	target, _ := time.Parse(time.DateOnly, g.TargetDate[:10])
+	now, _ := time.Parse(time.DateOnly, today)
+	switch {
+	case !now.After(start):
+	case !now.Before(target):
+		p.Expected = g.TargetAmount
+	default:
+		p.Expected = roundCents(g.TargetAmount * now.Sub(start).Hours() / target.Sub(start).Hours())
+	}
+
+	p.MonthsLeft = max(monthsBetween(today[:7], g.TargetDate[:7])+1, 0)
+	p.RequiredMonthly = roundCents(p.Remaining / float64(max(p.MonthsLeft, 1)))
+
+	switch {
+	case saved >= g.TargetAmount:
+		p.Status = goalAchieved
+	case saved >= p.Expected:
+		p.Status = goalOnTrack
+	default:
+		p.Status = goalBehind
+	}
+	return p
+}
+
+// hasTag reports whether text carries #tag, in any case, as a whole tag:
+// #trip does not match #trips
+func hasTag(text, tag string) bool {
+	text, needle := strings.ToLower(text), "#"+tag
+	for i := strings.Index(text, needle); i >= 0; {
+		end := i + len(needle)
+		if end == len(text) || !isTagChar(text[end]) {
+			return true
+		}
+		next := strings.Index(text[end:], needle)
+		if next < 0 {
+			break
+		}
+		i = end + next
+	}
+	return false
+}
+
+func isTagChar(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '_' || b == '-'
+}
@@ -2,20 +2,38 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
 )
 
-// healthCheck handles the health check endpoint
-func healthCheck(c *gin.Context) {
-	if err := db.Ping(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status": "unhealthy",
-			"error":  err.Error(),
+// Server holds the dependencies shared by the HTTP handlers
+type Server struct {
+	store    Store
+	cache    Cache // nil when caching is disabled
+	cfg      Config
+	metrics  *metrics
+	flights  singleflight.Group // coalesces concurrent cache fills per key
+	events   *eventBroker
+	webhooks *webhookDispatcher
+	outbox   *outboxRelay
+	channels []notificationChannel
+}
+
+// healthCheck handles the health check endpoint. Like /readyz it reports
+// failures with fixed values; why the database is unreachable is only
+// logged.
+func (s *Server) healthCheck(c *gin.Context) {
+	if err := s.store.Ping(c.Request.Context()); err != nil {
+		slog.WarnContext(c.Request.Context(), "Health check failed", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "unhealthy",
+			"service": "transaction-service",
 		})
 		return
 	}
@@ -26,225 +44,345 @@ func healthCheck(c *gin.Context) {
 	})
 }
 
-// getTransactions retrieves all transactions with optional Redis caching
-func getTransactions(c *gin.Context) {
-	ctx := context.Background()
-
-	// Try to get from cache
-	if redisClient != nil {
-		cached, err := redisClient.Get(ctx, "transactions").Result()
-		if err == nil {
-			var transactions []Transaction
-			if err := json.Unmarshal([]byte(cached), &transactions); err == nil {
-				c.JSON(http.StatusOK, transactions)
-				return
-			}
-		}
+// getTransactions retrieves all transactions with optional caching
+func (s *Server) getTransactions(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	key := s.cacheKey(ctx, "transactions", nsTransactions, nsCategories)
+	data, err := s.cached(ctx, key, s.cfg.Cache.TransactionsTTL, func(ctx context.Context) (any, error) {
+		return s.store.ListTransactions(ctx, 100)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
 	}
 
-	// Query database
-	query := `
-		SELECT t.id, t.date, t.description, t.amount, t.category_id, t.type, t.notes, t.created_at,
-		       c.name as category_name, c.color as category_color
-		FROM transactions t
-		LEFT JOIN categories c ON t.category_id = c.id
-		ORDER BY t.date DESC
-		LIMIT 100
-	`
+	respondCachedJSON(c, data)
+}
 
-	rows, err := db.Query(query)
+// getTransaction retrieves one transaction with its ETag
+func (s *Server) getTransaction(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, badRequest("invalid transaction id"))
 		return
 	}
-	defer rows.Close()
 
-	// ensure empty array ([]) instead of null when no rows
-	transactions := make([]Transaction, 0)
-  
-	for rows.Next() {
-		var t Transaction
-		err := rows.Scan(
-			&t.ID, &t.Date, &t.Description, &t.Amount, &t.CategoryID, &t.Type, &t.Notes, &t.CreatedAt,
-			&t.CategoryName, &t.CategoryColor,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		transactions = append(transactions, t)
+	t, err := s.store.GetTransaction(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
 	}
-
-	// Cache for 60 seconds
-	if redisClient != nil {
-		if data, err := json.Marshal(transactions); err == nil {
-			redisClient.SetEx(ctx, "transactions", data, 60*time.Second)
-		}
+	if notModified(c, versionETag(t.Version)) {
+		return
 	}
 
-	c.JSON(http.StatusOK, transactions)
+	c.JSON(http.StatusOK, t)
 }
 
 // addTransaction creates a new transaction
-func addTransaction(c *gin.Context) {
+func (s *Server) addTransaction(c *gin.Context) {
 	var t Transaction
 	if err := c.ShouldBindJSON(&t); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, bindError(err))
 		return
 	}
+	t.CreatedBy = &currentMember(c).ID
 
-	query := `
-		INSERT INTO transactions (date, description, amount, category_id, type, notes)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, date, description, amount, category_id, type, notes, created_at
-	`
-
-	var result Transaction
-	err := db.QueryRow(
-		query, t.Date, t.Description, t.Amount, t.CategoryID, t.Type, t.Notes,
-	).Scan(
-		&result.ID, &result.Date, &result.Description, &result.Amount,
-		&result.CategoryID, &result.Type, &result.Notes, &result.CreatedAt,
-	)
-
+	ctx := c.Request.Context()
+	result, err := s.store.CreateTransaction(ctx, t, func(t Transaction) []Event {
+		return transactionEvents(c, eventTransactionCreated, t.ID, t)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, categoryReferenceError(err))
 		return
 	}
+	s.metrics.transactionCreated(result.Type)
 
-	// Invalidate cache
-	ctx := context.Background()
-	if redisClient != nil {
-		redisClient.Del(ctx, "transactions")
-		redisClient.Del(ctx, "analytics")
+	s.bumpVersion(ctx, nsTransactions)
+	s.outbox.nudge()
+	if month := currentMonth(); budgetSpending(result, month) > 0 {
+		s.evaluateBudget(ctx, result.CategoryID, month)
 	}
 
 	c.JSON(http.StatusCreated, result)
 }
 
-// deleteTransaction removes a transaction by ID
-func deleteTransaction(c *gin.Context) {
+// categoryReferenceError points a foreign key failure at category_id, the
+// only reference a client controls in transactions, budgets and goals
+func categoryReferenceError(err error) error {
+	if e := toAPIError(err); e.Code == codeInvalidReference {
+		e.Details = []fieldError{{Field: "category_id", Message: "category does not exist"}}
+		return e
+	}
+	return err
+}
+
+// createdBy reports whether m created t
+func createdBy(t Transaction, m *Member) bool {
+	return t.CreatedBy != nil && *t.CreatedBy == m.ID
+}
+
+// updateTransaction replaces a transaction. Members without
+// PermEditAnyTransaction may only edit the transactions they created.
+func (s *Server) updateTransaction(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction id"})
+		respondError(c, badRequest("invalid transaction id"))
+		return
+	}
+	var t Transaction
+	if err := c.ShouldBindJSON(&t); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	current, err := s.store.GetTransaction(ctx, id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	member := currentMember(c)
+	if !member.Role.can(PermEditAnyTransaction) && !createdBy(current, member) {
+		respondError(c, forbidden("you can only edit your own transactions"))
+		return
+	}
+	expected, ok := s.ifMatch(c, current.Version)
+	if !ok {
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM transactions WHERE id = $1", id)
+	t.ID = id
+	result, err := s.store.UpdateTransaction(ctx, t, expected, func(t Transaction) []Event {
+		return transactionEvents(c, eventTransactionUpdated, t.ID, t)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, categoryReferenceError(err))
 		return
 	}
 
-	// Invalidate cache
-	ctx := context.Background()
-	if redisClient != nil {
-		redisClient.Del(ctx, "transactions")
-		redisClient.Del(ctx, "analytics")
+	s.bumpVersion(ctx, nsTransactions)
+	s.outbox.nudge()
+	if month := currentMonth(); budgetSpending(result, month) > 0 {
+		s.evaluateBudget(ctx, result.CategoryID, month)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Transaction deleted"})
+	c.Header("ETag", versionETag(result.Version))
+	c.JSON(http.StatusOK, result)
 }
 
-// getCategories retrieves all categories
-func getCategories(c *gin.Context) {
-	rows, err := db.Query("SELECT id, name, type, color, created_at FROM categories ORDER BY name")
+// deleteTransaction removes a transaction by ID. Members without
+// PermDeleteAnyTransaction may only delete the transactions they created.
+func (s *Server) deleteTransaction(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, badRequest("invalid transaction id"))
 		return
 	}
-	defer rows.Close()
 
-	var categories []Category
-	for rows.Next() {
-		var cat Category
-		err := rows.Scan(&cat.ID, &cat.Name, &cat.Type, &cat.Color, &cat.CreatedAt)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	ctx := c.Request.Context()
+	t, err := s.store.GetTransaction(ctx, id)
+	if err != nil && !errors.Is(err, errNotFound) {
+		respondError(c, err)
+		return
+	}
+	var expected int
+	if err == nil {
+		member := currentMember(c)
+		if !member.Role.can(PermDeleteAnyTransaction) && !createdBy(t, member) {
+			respondError(c, forbidden("you can only delete your own transactions"))
+			return
+		}
+		var ok bool
+		if expected, ok = s.ifMatch(c, t.Version); !ok {
 			return
 		}
-		categories = append(categories, cat)
+	} else if c.GetHeader("If-Match") != "" {
+		// A precondition can never hold for a transaction that is gone
+		respondError(c, errVersionMismatch)
+		return
+	}
+
+	events := transactionEvents(c, eventTransactionDeleted, id, gin.H{"id": id})
+	if err := s.store.DeleteTransaction(ctx, id, expected, events); err != nil {
+		respondError(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, categories)
+	s.bumpVersion(ctx, nsTransactions)
+	s.outbox.nudge()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transaction deleted"})
 }
 
-// getAnalytics retrieves analytics data with optional Redis caching
-func getAnalytics(c *gin.Context) {
-	ctx := context.Background()
-
-	// Try to get from cache
-	if redisClient != nil {
-		cached, err := redisClient.Get(ctx, "analytics").Result()
-		if err == nil {
-			var analytics Analytics
-			if err := json.Unmarshal([]byte(cached), &analytics); err == nil {
-				c.JSON(http.StatusOK, analytics)
-				return
-			}
+// getCategories retrieves all categories as a tree of subcategories, or as
+// a flat list with ?flat=true
+func (s *Server) getCategories(c *gin.Context) {
+	flat, err := strconv.ParseBool(c.DefaultQuery("flat", "false"))
+	if err != nil {
+		respondError(c, invalidField("flat", "must be true or false"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	name := "categories-tree"
+	if flat {
+		name = "categories"
+	}
+	key := s.cacheKey(ctx, name, nsCategories)
+	data, err := s.cached(ctx, key, s.cfg.Cache.CategoriesTTL, func(ctx context.Context) (any, error) {
+		categories, err := s.store.ListCategories(ctx)
+		if err != nil || flat {
+			return categories, err
 		}
+		return categoryTree(categories), nil
+	})
+	if err != nil {
+		respondError(c, err)
+		return
 	}
 
-	// Query summary
-	summaryQuery := `
-		SELECT 
-			COALESCE(SUM(CASE WHEN type = 'income' THEN amount ELSE 0 END), 0) as total_income,
-			COALESCE(SUM(CASE WHEN type = 'expense' THEN amount ELSE 0 END), 0) as total_expenses,
-			COUNT(*) as transaction_count
-		FROM transactions
-		WHERE date >= CURRENT_DATE - INTERVAL '30 days'
-	`
+	respondCachedJSON(c, data)
+}
+
+// getCategory retrieves one category with its ETag
+func (s *Server) getCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid category id"))
+		return
+	}
 
-	var summary AnalyticsSummary
-	err := db.QueryRow(summaryQuery).Scan(
-		&summary.TotalIncome, &summary.TotalExpenses, &summary.TransactionCount,
-	)
+	cat, err := s.store.GetCategory(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
+		return
+	}
+	if notModified(c, versionETag(cat.Version)) {
 		return
 	}
 
-	// Query by category
-	categoryQuery := `
-		SELECT c.name, c.color, COALESCE(SUM(t.amount), 0) as total
-		FROM transactions t
-		JOIN categories c ON t.category_id = c.id
-		WHERE t.date >= CURRENT_DATE - INTERVAL '30 days' AND t.type = 'expense'
-		GROUP BY c.name, c.color
-		ORDER BY total DESC
-	`
+	c.JSON(http.StatusOK, cat)
+}
+
+// createCategory adds a category, optionally as a subcategory of another
+// category of the same type
+func (s *Server) createCategory(c *gin.Context) {
+	var cat Category
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
 
-	rows, err := db.Query(categoryQuery)
+	ctx := c.Request.Context()
+	if err := s.checkCategoryParent(ctx, cat); err != nil {
+		respondError(c, err)
+		return
+	}
+	result, err := s.store.CreateCategory(ctx, cat, func(cat Category) []Event {
+		return []Event{newEvent(c, eventCategoryCreated, fmt.Sprintf("category:%d", cat.ID), PermViewCategories, cat)}
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, categoryWriteError(err))
 		return
 	}
-	defer rows.Close()
 
-	// ensure empty array ([]) instead of null when no rows
-	byCategory := make([]CategoryAnalytics, 0)
-  
-	for rows.Next() {
-		var cat CategoryAnalytics
-		err := rows.Scan(&cat.Name, &cat.Color, &cat.Total)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	s.bumpVersion(ctx, nsCategories)
+	s.outbox.nudge()
+
+	c.Header("ETag", versionETag(result.Version))
+	c.JSON(http.StatusCreated, result)
+}
+
+// categoryWriteError explains the store errors of a category write
+func categoryWriteError(err error) error {
+	switch {
+	case errors.Is(err, errConflict) || isUniqueViolation(err):
+		return conflict("a category with this name and type already exists")
+	case errors.Is(err, errCategoryCycle):
+		return invalidField("parent_id", "must not be the category itself or one of its subcategories")
+	case errors.Is(err, errInvalidReference) || isForeignKeyViolation(err):
+		return invalidField("parent_id", "category does not exist")
+	}
+	return err
+}
+
+// updateCategory renames, recolours or moves a category
+func (s *Server) updateCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid category id"))
+		return
+	}
+	var cat Category
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	current, err := s.store.GetCategory(ctx, id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	expected, ok := s.ifMatch(c, current.Version)
+	if !ok {
+		return
+	}
+
+	cat.ID = id
+	if err := s.checkCategoryParent(ctx, cat); err != nil {
+		respondError(c, err)
+		return
+	}
+	result, err := s.store.UpdateCategory(ctx, cat, expected, func(cat Category) []Event {
+		return []Event{
+			newEvent(c, eventCategoryUpdated, fmt.Sprintf("category:%d", cat.ID), PermViewCategories, cat),
+			// Analytics are broken down by category name
+			newEvent(c, eventAnalyticsChanged, "analytics", PermViewAnalytics, gin.H{}),
 		}
-		byCategory = append(byCategory, cat)
+	})
+	if err != nil {
+		respondError(c, categoryWriteError(err))
+		return
 	}
 
-	analytics := Analytics{
-		Summary:    summary,
-		ByCategory: byCategory,
+	s.bumpVersion(ctx, nsCategories)
+	s.outbox.nudge()
+
+	c.Header("ETag", versionETag(result.Version))
+	c.JSON(http.StatusOK, result)
+}
+
+// getAnalytics retrieves analytics data with optional caching. ?depth=
+// rolls subcategories up into their ancestors at that level.
+func (s *Server) getAnalytics(c *gin.Context) {
+	depth, err := queryDepth(c)
+	if err != nil {
+		respondError(c, err)
+		return
 	}
 
-	// Cache for 5 minutes
-	if redisClient != nil {
-		if data, err := json.Marshal(analytics); err == nil {
-			redisClient.SetEx(ctx, "analytics", data, 5*time.Minute)
+	ctx := c.Request.Context()
+	name := "analytics"
+	if depth > 0 {
+		name += ":depth=" + strconv.Itoa(depth)
+	}
+	key := s.cacheKey(ctx, name, nsTransactions, nsCategories, nsGoals)
+	data, err := s.cached(ctx, key, s.cfg.Cache.AnalyticsTTL, func(ctx context.Context) (any, error) {
+		analytics, err := s.store.Analytics(ctx, depth)
+		if err != nil {
+			return nil, err
 		}
+		analytics.Goals, err = s.goalsWithProgress(ctx)
+		return analytics, err
+	})
+	if err != nil {
+		respondError(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, analytics)
+	respondCachedJSON(c, data)
 }
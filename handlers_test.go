@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiErrorBody is the error envelope as clients see it
+type apiErrorBody struct {
+	Error struct {
+		Code    string       `json:"code"`
+		Message string       `json:"message"`
+		Details []fieldError `json:"details"`
+	} `json:"error"`
+}
+
+func TestTransactionCRUD(t *testing.T) {
+	s, h := newTestServer(t)
+	_, token := addMember(t, s, "Owner", RoleOwner)
+
+	rec := request(h, "POST", "/api/transactions", token,
+		`{"date":"2026-10-01","description":"Groceries","amount":42.5,"type":"expense","category_id":1}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create got %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var created Transaction
+	decode(t, rec, &created)
+	if created.ID == 0 || created.Amount != 42.5 || created.Version != 1 {
+		t.Errorf("created %+v", created)
+	}
+	path := "/api/transactions/" + strconv.Itoa(created.ID)
+
+	rec = request(h, "GET", path, token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"1"` {
+		t.Errorf("ETag = %s, want \"1\"", etag)
+	}
+	var got Transaction
+	decode(t, rec, &got)
+	if got.CategoryName == nil || *got.CategoryName != "Groceries" {
+		t.Errorf("category_name = %v, want Groceries", got.CategoryName)
+	}
+
+	rec = request(h, "GET", "/api/transactions", token, "")
+	var list []Transaction
+	decode(t, rec, &list)
+	if len(list) != 1 || list[0].ID != created.ID {
+		t.Fatalf("list = %+v, want the created transaction", list)
+	}
+
+	update := `{"date":"2026-10-02","description":"Groceries and wine","amount":55,"type":"expense","category_id":1}`
+	rec = request(h, "PUT", path, token, update)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var updated Transaction
+	decode(t, rec, &updated)
+	if updated.Amount != 55 || updated.Description != "Groceries and wine" || updated.Version != 2 {
+		t.Errorf("updated %+v", updated)
+	}
+
+	// The list is cached; the update must invalidate it
+	rec = request(h, "GET", "/api/transactions", token, "")
+	decode(t, rec, &list)
+	if len(list) != 1 || list[0].Amount != 55 {
+		t.Errorf("list after update = %+v, want amount 55", list)
+	}
+
+	rec = request(h, "DELETE", path, token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec = request(h, "GET", path, token, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete got %d, want 404", rec.Code)
+	}
+}
+
+func TestTransactionIfMatch(t *testing.T) {
+	s, h := newTestServer(t)
+	_, token := addMember(t, s, "Owner", RoleOwner)
+	rec := request(h, "POST", "/api/transactions", token,
+		`{"date":"2026-10-01","description":"Rent","amount":900,"type":"expense"}`)
+	var created Transaction
+	decode(t, rec, &created)
+	path := "/api/transactions/" + strconv.Itoa(created.ID)
+
+	req := newJSONRequest("PUT", path, token, `{"date":"2026-10-01","description":"Rent","amount":950,"type":"expense"}`)
+	req.Header.Set("If-Match", `"2"`)
+	if rec := serve(h, req); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match got %d, want 412: %s", rec.Code, rec.Body)
+	}
+	req = newJSONRequest("PUT", path, token, `{"date":"2026-10-01","description":"Rent","amount":950,"type":"expense"}`)
+	req.Header.Set("If-Match", `"1"`)
+	if rec := serve(h, req); rec.Code != http.StatusOK {
+		t.Errorf("current If-Match got %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTransactionErrors(t *testing.T) {
+	s, h := newTestServer(t)
+	_, token := addMember(t, s, "Owner", RoleOwner)
+
+	tests := []struct {
+		name, method, path, body string
+		status                   int
+		code, field              string
+	}{
+		{"missing transaction", "GET", "/api/transactions/999", "", http.StatusNotFound, codeNotFound, ""},
+		{"update missing transaction", "PUT", "/api/transactions/999",
+			`{"date":"2026-10-01","description":"x","amount":1,"type":"expense"}`, http.StatusNotFound, codeNotFound, ""},
+		{"missing category", "POST", "/api/transactions",
+			`{"date":"2026-10-01","description":"x","amount":1,"type":"expense","category_id":999}`,
+			http.StatusUnprocessableEntity, codeInvalidReference, "category_id"},
+		{"non-positive amount", "POST", "/api/transactions",
+			`{"date":"2026-10-01","description":"x","amount":-5,"type":"expense"}`,
+			http.StatusBadRequest, codeValidationFailed, "amount"},
+		{"unknown type", "POST", "/api/transactions",
+			`{"date":"2026-10-01","description":"x","amount":1,"type":"gift"}`,
+			http.StatusBadRequest, codeValidationFailed, "type"},
+		{"date that is not a date", "POST", "/api/transactions",
+			`{"date":"garbage","description":"x","amount":1,"type":"expense"}`,
+			http.StatusBadRequest, codeValidationFailed, "date"},
+		{"date with a time", "POST", "/api/transactions",
+			`{"date":"2026-10-01T10:00:00Z","description":"x","amount":1,"type":"expense"}`,
+			http.StatusBadRequest, codeValidationFailed, "date"},
+		{"malformed JSON", "POST", "/api/transactions", `{"date":`, http.StatusBadRequest, codeInvalidJSON, ""},
+		{"invalid id", "GET", "/api/transactions/abc", "", http.StatusBadRequest, codeValidationFailed, ""},
+		{"unknown route", "GET", "/api/nothing", "", http.StatusNotFound, codeNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(h, tt.method, tt.path, token, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var body apiErrorBody
+			decode(t, rec, &body)
+			if body.Error.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Error.Code, tt.code)
+			}
+			if tt.field == "" {
+				return
+			}
+			if len(body.Error.Details) == 0 || body.Error.Details[0].Field != tt.field {
+				t.Errorf("details = %+v, want field %s", body.Error.Details, tt.field)
+			}
+		})
+	}
+}
+
+func TestAnalytics(t *testing.T) {
+	s, h := newTestServer(t)
+	_, token := addMember(t, s, "Owner", RoleOwner)
+	today := time.Now().UTC().Format(time.DateOnly)
+	for _, body := range []string{
+		`{"date":"%s","description":"Salary","amount":3000,"type":"income","category_id":6}`,
+		`{"date":"%s","description":"Rent","amount":900,"type":"expense","category_id":2}`,
+		`{"date":"%s","description":"Groceries","amount":100,"type":"expense","category_id":1}`,
+		`{"date":"%s","description":"Market","amount":50,"type":"expense","category_id":1}`,
+	} {
+		if rec := request(h, "POST", "/api/transactions", token, fmt.Sprintf(body, today)); rec.Code != http.StatusCreated {
+			t.Fatalf("create got %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	rec := request(h, "GET", "/api/analytics", token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("analytics got %d: %s", rec.Code, rec.Body)
+	}
+	var a Analytics
+	decode(t, rec, &a)
+	if a.Summary.TotalIncome != 3000 || a.Summary.TotalExpenses != 1050 || a.Summary.TransactionCount != 4 {
+		t.Errorf("summary = %+v", a.Summary)
+	}
+	if len(a.ByCategory) != 2 || a.ByCategory[0].Name != "Rent" || a.ByCategory[1].Total != 150 {
+		t.Errorf("byCategory = %+v, want Rent 900 then Groceries 150", a.ByCategory)
+	}
+	if a.Goals == nil {
+		t.Error("goals is null, want []")
+	}
+
+	// Analytics are cached; a new transaction must invalidate them
+	request(h, "POST", "/api/transactions", token,
+		fmt.Sprintf(`{"date":"%s","description":"Bus","amount":20,"type":"expense","category_id":4}`, today))
+	decode(t, request(h, "GET", "/api/analytics", token, ""), &a)
+	if a.Summary.TotalExpenses != 1070 {
+		t.Errorf("total_expenses after a new expense = %v, want 1070", a.Summary.TotalExpenses)
+	}
+
+	// A matching If-None-Match is answered with 304
+	rec = request(h, "GET", "/api/analytics", token, "")
+	req := newJSONRequest("GET", "/api/analytics", token, "")
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	if rec := serve(h, req); rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match got %d, want 304", rec.Code)
+	}
+}
+
+func TestAnalyticsDepthRollsUpSubcategories(t *testing.T) {
+	s, h := newTestServer(t)
+	_, token := addMember(t, s, "Owner", RoleOwner)
+	rec := request(h, "POST", "/api/categories", token, `{"name":"Dining","type":"expense","color":"#000000","parent_id":1}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create category got %d: %s", rec.Code, rec.Body)
+	}
+	var dining Category
+	decode(t, rec, &dining)
+	today := time.Now().UTC().Format(time.DateOnly)
+	request(h, "POST", "/api/transactions", token,
+		fmt.Sprintf(`{"date":"%s","description":"Dinner","amount":60,"type":"expense","category_id":%d}`, today, dining.ID))
+	request(h, "POST", "/api/transactions", token,
+		fmt.Sprintf(`{"date":"%s","description":"Market","amount":40,"type":"expense","category_id":1}`, today))
+
+	var a Analytics
+	decode(t, request(h, "GET", "/api/analytics?depth=1", token, ""), &a)
+	if len(a.ByCategory) != 1 || a.ByCategory[0].ID != 1 || a.ByCategory[0].Total != 100 {
+		t.Errorf("depth=1 byCategory = %+v, want Groceries 100", a.ByCategory)
+	}
+	for _, depth := range []string{"0", "11", "1000000"} {
+		if rec := request(h, "GET", "/api/analytics?depth="+depth, token, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("depth=%s got %d, want 400", depth, rec.Code)
+		}
+	}
+	// Every depth shares the metric label of analytics
+	if label := cacheKeyLabel(s.cacheKey(context.Background(), "analytics:depth=3", nsTransactions)); label != "analytics" {
+		t.Errorf("cache metric label = %q, want analytics", label)
+	}
+}
+
+// unreachableStore is a store whose database cannot be reached
+type unreachableStore struct{ Store }
+
+func (unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("dial tcp 10.0.0.5:5432: connect: connection refused")
+}
+
+func TestHealthCheckHidesDatabaseErrors(t *testing.T) {
+	s, h := newTestServer(t)
+	s.store = unreachableStore{s.store}
+
+	rec := request(h, "GET", "/health", "", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "10.0.0.5") {
+		t.Errorf("body leaks the driver error: %s", rec.Body)
+	}
+	var body struct{ Status string }
+	decode(t, rec, &body)
+	if body.Status != "unhealthy" {
+		t.Errorf("status = %q, want unhealthy", body.Status)
+	}
+
+	rec = request(h, "GET", "/readyz", "", "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz got %d, want 503: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "10.0.0.5") {
+		t.Errorf("/readyz leaks the driver error: %s", rec.Body)
+	}
+	var ready readiness
+	decode(t, rec, &ready)
+	if db := ready.Checks["database"]; db.Status != statusDown || db.Error != checkFailed {
+		t.Errorf("database check = %+v, want down and unavailable", db)
+	}
+}
+
+func TestBudgetAlertsCountCarriedInBalance(t *testing.T) {
+	s, h := newTestServer(t)
+	_, token := addMember(t, s, "Owner", RoleOwner)
+	now := time.Now().UTC()
+	lastMonth := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)
+	today := now.Format(time.DateOnly)
+	post := func(path, body string) {
+		t.Helper()
+		if rec := request(h, "POST", path, token, body); rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s got %d: %s", path, rec.Code, rec.Body)
+		}
+	}
+	spend := func(date string, categoryID int, amount float64) {
+		t.Helper()
+		post("/api/transactions", fmt.Sprintf(`{"date":"%s","description":"Spending","amount":%v,"type":"expense","category_id":%d}`, date, amount, categoryID))
+	}
+	alerts := func() []Notification {
+		t.Helper()
+		var n []Notification
+		decode(t, request(h, "GET", "/api/notifications", token, ""), &n)
+		return n
+	}
+
+	// Groceries carries in last month's unspent 100, so 150 is 75% of 200
+	post("/api/budgets", fmt.Sprintf(`{"category_id":1,"amount":100,"start_date":"%s","rollover":"unused"}`, lastMonth))
+	spend(today, 1, 150)
+	if n := alerts(); len(n) != 0 {
+		t.Fatalf("alerts = %+v, want none below 80%% of the budget and the balance carried in", n)
+	}
+	spend(today, 1, 20)
+	n := alerts()
+	if len(n) != 1 || n[0].Type != eventBudgetThreshold || *n[0].Threshold != 80 || *n[0].Amount != 200 {
+		t.Fatalf("alerts = %+v, want an 80%% alert of 200", n)
+	}
+
+	// Rent carries in last month's overspending, so 50 uses up what is left
+	post("/api/budgets", fmt.Sprintf(`{"category_id":2,"amount":100,"start_date":"%s","rollover":"overspend"}`, lastMonth))
+	spend(lastMonth, 2, 150)
+	spend(today, 2, 50)
+	n = alerts()
+	if len(n) != 2 || n[0].Type != eventBudgetExceeded || *n[0].Amount != 50 {
+		t.Fatalf("alerts = %+v, want rent exceeded at 50", n)
+	}
+}
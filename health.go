@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Dependency states reported by /readyz
+const (
+	statusUp       = "up"
+	statusDown     = "down"
+	statusDisabled = "disabled"
+)
+
+// dependencyCheck is the result of checking one dependency
+type dependencyCheck struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	Version   *int    `json:"version,omitempty"`
+	Expected  *int    `json:"expected,omitempty"`
+}
+
+// readiness is the /readyz response body
+type readiness struct {
+	Status string                     `json:"status"`
+	Checks map[string]dependencyCheck `json:"checks"`
+	Cache  *cacheStats                `json:"cache,omitempty"`
+}
+
+// livez reports that the process is up and serving HTTP. It deliberately
+// checks no dependencies so a database outage never restarts the pod.
+func (s *Server) livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// readyz reports whether the service can handle traffic. The database and
+// schema version are required; Redis is optional, so an unreachable Redis
+// only marks the service as degraded.
+func (s *Server) readyz(c *gin.Context) {
+	ctx := c.Request.Context()
+	result := readiness{Status: "ready", Checks: map[string]dependencyCheck{}}
+
+	db := s.check(ctx, "database", s.store.Ping)
+	result.Checks["database"] = db
+
+	var applied, latest int
+	migrations := s.check(ctx, "migrations", func(ctx context.Context) error {
+		var err error
+		applied, latest, err = s.store.SchemaVersion(ctx)
+		// A newer replica may already have migrated further during a
+		// rolling deploy; only a schema behind this build is a problem
+		if err == nil && applied < latest {
+			err = fmt.Errorf("schema is at version %d, expected at least %d", applied, latest)
+		}
+		return err
+	})
+	migrations.Version, migrations.Expected = &applied, &latest
+	result.Checks["migrations"] = migrations
+
+	if s.cache == nil {
+		result.Checks["redis"] = dependencyCheck{Status: statusDisabled}
+	} else {
+		stats := s.cache.Stats()
+		result.Cache = &stats
+		if stats.Mode == "memory" {
+			result.Checks["redis"] = dependencyCheck{Status: statusDisabled}
+		} else {
+			result.Checks["redis"] = s.check(ctx, "redis", s.cache.Ping)
+		}
+	}
+
+	switch {
+	case db.Status != statusUp || migrations.Status != statusUp:
+		result.Status = "unavailable"
+		c.JSON(http.StatusServiceUnavailable, result)
+		return
+	case result.Checks["redis"].Status == statusDown:
+		result.Status = "degraded"
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// checkFailed is the error reported for a failed check. Driver and Redis
+// errors can name hosts, users and SQL state, so they are only logged.
+const checkFailed = "unavailable"
+
+// check runs fn with the configured health timeout and times it
+func (s *Server) check(ctx context.Context, name string, fn func(context.Context) error) dependencyCheck {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Health.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(ctx)
+	result := dependencyCheck{
+		Status:    statusUp,
+		LatencyMS: milliseconds(time.Since(start)),
+	}
+	if err != nil {
+		result.Status = statusDown
+		result.Error = checkFailed
+		slog.WarnContext(ctx, "Readiness check failed", "check", name, "error", err)
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyKeyHeader lets clients retry a POST without creating duplicates
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotent makes a route safe to retry. The first request with a given
+// Idempotency-Key runs normally and its response is stored; repeats get the
+// stored response back instead of running the handler again. Keys are scoped
+// to the member and kept for cfg.Idempotency.TTL. The store is the source of
+// truth; completed responses are also cached so repeats skip the database.
+func (s *Server) idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if !printableASCII(key, 255) {
+			respondError(c, badRequest("Idempotency-Key must be 1 to 255 printable ASCII characters"))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondError(c, badRequest("could not read request body"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		memberID := currentMember(c).ID
+		hash := requestHash(c.Request, body)
+		cacheKey := fmt.Sprintf("idempotency:%d:%s", memberID, key)
+
+		if s.cache != nil {
+			if cached, ok := s.cache.Get(ctx, cacheKey); ok {
+				var rec IdempotencyRecord
+				if err := json.Unmarshal(cached, &rec); err == nil {
+					replayIdempotent(c, rec, hash)
+					return
+				}
+			}
+		}
+
+		now := time.Now()
+		rec := IdempotencyRecord{RequestHash: hash, ExpiresAt: now.Add(s.cfg.Idempotency.TTL)}
+		// A key still in progress after InProgressTTL belongs to a crashed or
+		// abandoned request
+		staleBefore := now.Add(-s.cfg.Idempotency.InProgressTTL)
+		existing, reserved, err := s.store.ReserveIdempotencyKey(ctx, memberID, key, rec, now, staleBefore)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if !reserved {
+			replayIdempotent(c, existing, hash)
+			return
+		}
+
+		w := &bufferingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		// The request context may have timed out; the outcome must still be
+		// recorded or released.
+		ctx = context.WithoutCancel(ctx)
+		if w.Status() >= 500 {
+			if err := s.store.ReleaseIdempotencyKey(ctx, memberID, key); err != nil {
+				slog.ErrorContext(ctx, "Releasing idempotency key failed", "error", err)
+			}
+			return
+		}
+		rec.StatusCode, rec.Body = w.Status(), w.body.Bytes()
+		if err := s.store.CompleteIdempotencyKey(ctx, memberID, key, rec.StatusCode, rec.Body); err != nil {
+			slog.ErrorContext(ctx, "Storing idempotent response failed", "error", err)
+			return
+		}
+		if s.cache != nil {
+			if data, err := json.Marshal(rec); err == nil {
+				s.cache.Set(ctx, cacheKey, data, time.Until(rec.ExpiresAt))
+			}
+		}
+	}
+}
+
+// replayIdempotent answers a repeated request from its stored record
+func replayIdempotent(c *gin.Context, rec IdempotencyRecord, hash string) {
+	switch {
+	case rec.RequestHash != hash:
+		respondError(c, &apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    codeIdempotencyKeyReused,
+			Message: "Idempotency-Key was already used with a different request",
+		})
+	case rec.StatusCode == 0:
+		respondError(c, &apiError{
+			Status:  http.StatusConflict,
+			Code:    codeIdempotencyInProgress,
+			Message: "a request with this Idempotency-Key is still in progress",
+		})
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(rec.StatusCode, "application/json; charset=utf-8", rec.Body)
+		c.Abort()
+	}
+}
+
+// requestHash fingerprints the method, path and body of a request
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bufferingWriter keeps a copy of the response body
+type bufferingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bufferingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// purgeIdempotencyKeys deletes expired keys every interval until ctx ends
+func purgeIdempotencyKeys(ctx context.Context, store IdempotencyStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.PurgeIdempotencyKeys(ctx, time.Now())
+			if err != nil {
+				slog.Warn("Purging idempotency keys failed", "error", err)
+			} else if n > 0 {
+				slog.Info("Purged expired idempotency keys", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// blockingStore holds the first transaction insert until release is
+// closed; later inserts go straight through
+type blockingStore struct {
+	Store
+	calls   *atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockingStore) CreateTransaction(ctx context.Context, t Transaction, events func(Transaction) []Event) (Transaction, error) {
+	if s.calls.Add(1) == 1 {
+		close(s.started)
+		<-s.release
+	}
+	return s.Store.CreateTransaction(ctx, t, events)
+}
+
+func TestIdempotencyKeyCreatesOneTransaction(t *testing.T) {
+	s, h := newTestServer(t)
+	// A write timeout of zero used to make every reservation stale at once
+	s.cfg.Server.WriteTimeout = 0
+	_, token := addMember(t, s, "Owner", RoleOwner)
+	store := blockingStore{Store: s.store, calls: &atomic.Int32{}, started: make(chan struct{}), release: make(chan struct{})}
+	s.store = store
+
+	body := `{"date":"2026-10-01","description":"Groceries","amount":42.5,"type":"expense"}`
+	post := func() *http.Request {
+		req := newJSONRequest("POST", "/api/transactions", token, body)
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		return req
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var first int
+	go func() {
+		defer wg.Done()
+		first = serve(h, post()).Code
+	}()
+	<-store.started
+
+	// The same key while the first request is still running
+	rec := serve(h, post())
+	if rec.Code != http.StatusConflict {
+		t.Errorf("concurrent retry got %d, want 409: %s", rec.Code, rec.Body)
+	}
+	close(store.release)
+	wg.Wait()
+	if first != http.StatusCreated {
+		t.Errorf("first request got %d, want 201", first)
+	}
+
+	rec = serve(h, post())
+	if rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry got %d replayed=%q, want the stored 201", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+	}
+	list, err := s.store.ListTransactions(context.Background(), 100)
+	if err != nil || len(list) != 1 {
+		t.Errorf("transactions = %d, %v; want 1", len(list), err)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ledgerMaxMonths bounds how many months one ledger request may show
+const ledgerMaxMonths = 60
+
+// ledgerMaxReplayMonths bounds how many months a ledger is replayed over, so
+// a budget dated decades back cannot make every request walk them all.
+// Envelope balances from before then are not carried.
+const ledgerMaxReplayMonths = 120
+
+// getLedger shows budgets, envelopes and money ready to assign month by
+// month, ?from=YYYY-MM&to=YYYY-MM. It defaults to the last six months.
+// ?depth= merges the envelopes of subcategories into their ancestors at that
+// level.
+func (s *Server) getLedger(c *gin.Context) {
+	to := c.DefaultQuery("to", currentMonth())
+	if !validMonth(to) {
+		respondError(c, invalidField("to", "must be a month formatted as YYYY-MM"))
+		return
+	}
+	from := c.DefaultQuery("from", addMonths(to, -5))
+	if !validMonth(from) {
+		respondError(c, invalidField("from", "must be a month formatted as YYYY-MM"))
+		return
+	}
+	if from > to {
+		respondError(c, invalidField("from", "must not be after to"))
+		return
+	}
+	if monthsBetween(from, to) >= ledgerMaxMonths {
+		respondError(c, invalidField("from", fmt.Sprintf("must be less than %d months before to", ledgerMaxMonths)))
+		return
+	}
+	depth, err := queryDepth(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	ledger, err := s.loadLedger(c.Request.Context(), from, to, depth)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ledger)
+}
+
+// loadLedger reads what buildLedger needs from the store and builds the
+// ledger of the months from from to to
+func (s *Server) loadLedger(ctx context.Context, from, to string, depth int) (Ledger, error) {
+	budgets, err := s.store.ListBudgets(ctx)
+	if err != nil {
+		return Ledger{}, err
+	}
+	allocations, err := s.store.ListAllocations(ctx, to)
+	if err != nil {
+		return Ledger{}, err
+	}
+	totals, err := s.store.MonthlyTotals(ctx, to)
+	if err != nil {
+		return Ledger{}, err
+	}
+	categories, err := s.store.ListCategories(ctx)
+	if err != nil {
+		return Ledger{}, err
+	}
+	return buildLedger(from, to, depth, budgets, allocations, totals, categories), nil
+}
+
+// setAllocation assigns money to a category's envelope for a month,
+// replacing what was assigned before. Assigning 0 empties it.
+func (s *Server) setAllocation(c *gin.Context) {
+	month := c.Param("month")
+	if !validMonth(month) {
+		respondError(c, invalidField("month", "must be a month formatted as YYYY-MM"))
+		return
+	}
+	categoryID, err := strconv.Atoi(c.Param("category_id"))
+	if err != nil {
+		respondError(c, badRequest("invalid category id"))
+		return
+	}
+	var a Allocation
+	if err := c.ShouldBindJSON(&a); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := s.checkEnvelopeCategory(ctx, categoryID); err != nil {
+		respondError(c, err)
+		return
+	}
+	member := currentMember(c)
+	a.Month, a.CategoryID, a.UpdatedBy = month, categoryID, &member.ID
+	result, err := s.store.SetAllocation(ctx, a, func(a Allocation) []Event {
+		aggregate := fmt.Sprintf("allocation:%s:%d", a.Month, a.CategoryID)
+		return []Event{newEvent(c, eventBudgetAssigned, aggregate, PermViewAnalytics, a)}
+	})
+	if err != nil {
+		respondError(c, categoryReferenceError(err))
+		return
+	}
+
+	s.outbox.nudge()
+
+	c.JSON(http.StatusOK, result)
+}
+
+// buildLedger replays every month from the first budget or allocation up to
+// to, at most ledgerMaxReplayMonths, carrying envelope balances forward, and
+// returns the months from from on. A category's envelope follows the
+// rollover of the budget in effect; a category with money assigned but no
+// budget keeps what it does not spend.
+// Spending in a category without an envelope is taken from the envelope of
+// its nearest ancestor with one.
+func buildLedger(from, to string, depth int, budgets []Budget, allocations []Allocation, totals []MonthlyTotal, categories []Category) Ledger {
+	start := from
+	for _, b := range budgets {
+		start = min(start, b.StartDate[:7])
+	}
+	for _, a := range allocations {
+		start = min(start, a.Month)
+	}
+	start = max(start, addMonths(to, 1-ledgerMaxReplayMonths))
+
+	names := map[int]string{}
+	for _, cat := range categories {
+		names[cat.ID] = cat.Name
+	}
+	parents := categoryParents(categories)
+	// Budgets by category, oldest first, so the last one started is in effect
+	budgetsOf := map[int][]Budget{}
+	sort.SliceStable(budgets, func(i, j int) bool { return budgets[i].StartDate < budgets[j].StartDate })
+	for _, b := range budgets {
+		budgetsOf[b.CategoryID] = append(budgetsOf[b.CategoryID], b)
+	}
+	// Income and assignments from before the replay only count towards the
+	// money ready to assign
+	ready := 0.0
+	assigned := map[monthCategory]float64{}
+	assignedIn := map[string]float64{}
+	for _, a := range allocations {
+		if a.Month < start {
+			ready -= a.Amount
+			continue
+		}
+		assigned[monthCategory{a.Month, a.CategoryID}] += a.Amount
+		assignedIn[a.Month] += a.Amount
+	}
+	spentIn := map[string]map[int]float64{}
+	income := map[string]float64{}
+	for _, t := range totals {
+		switch {
+		case t.Type == "income" && t.Month < start:
+			ready += t.Total
+		case t.Type == "income":
+			income[t.Month] += t.Total
+		case t.CategoryID != nil:
+			if spentIn[t.Month] == nil {
+				spentIn[t.Month] = map[int]float64{}
+			}
+			spentIn[t.Month][*t.CategoryID] += t.Total
+		}
+	}
+
+	ledger := Ledger{From: from, To: to, Months: []LedgerMonth{}}
+	carry := map[int]float64{}
+	for month := start; month <= to; month = addMonths(month, 1) {
+		ready += income[month] - assignedIn[month]
+		lm := LedgerMonth{
+			Month:         month,
+			Income:        roundCents(income[month]),
+			Assigned:      roundCents(assignedIn[month]),
+			ReadyToAssign: roundCents(ready),
+			Envelopes:     []LedgerEnvelope{},
+		}
+
+		ids := envelopeCategories(month, budgetsOf, assigned, carry)
+		spent := map[int]float64{}
+		for id, total := range spentIn[month] {
+			for _, envelope := range categoryPath(id, parents) {
+				if slices.Contains(ids, envelope) {
+					spent[envelope] += total
+					break
+				}
+			}
+		}
+
+		var envelopes []LedgerEnvelope
+		next := map[int]float64{}
+		for _, id := range ids {
+			e := LedgerEnvelope{
+				CategoryID:   id,
+				CategoryName: names[id],
+				Rollover:     rolloverUnused,
+				CarriedIn:    carry[id],
+				Assigned:     assigned[monthCategory{month, id}],
+				Spent:        spent[id],
+			}
+			if b, ok := budgetInEffect(budgetsOf[id], month); ok {
+				e.Budgeted, e.Rollover = b.Amount, b.Rollover
+			}
+			e.Available = e.CarriedIn + e.Budgeted + e.Assigned - e.Spent
+			e.CarriedOut = carriedOut(e.Available, e.Rollover)
+			if e.CarriedOut != 0 {
+				next[id] = e.CarriedOut
+			}
+			envelopes = append(envelopes, e)
+		}
+		carry = next
+		if depth > 0 {
+			envelopes = rollUpEnvelopes(envelopes, depth, parents, names)
+		}
+		for _, e := range envelopes {
+			lm.Envelopes = append(lm.Envelopes, roundEnvelope(e))
+		}
+		sort.Slice(lm.Envelopes, func(i, j int) bool {
+			if lm.Envelopes[i].CategoryName != lm.Envelopes[j].CategoryName {
+				return lm.Envelopes[i].CategoryName < lm.Envelopes[j].CategoryName
+			}
+			return lm.Envelopes[i].CategoryID < lm.Envelopes[j].CategoryID
+		})
+
+		if month >= from {
+			ledger.Months = append(ledger.Months, lm)
+		}
+	}
+	return ledger
+}
+
+// monthCategory keys ledger amounts by month and category
+type monthCategory struct {
+	month      string
+	categoryID int
+}
+
+// envelopeCategories returns the categories with an envelope in month: those
+// with a budget in effect, money assigned or a balance carried in
+func envelopeCategories(month string, budgetsOf map[int][]Budget, assigned map[monthCategory]float64, carry map[int]float64) []int {
+	seen := map[int]bool{}
+	for id, budgets := range budgetsOf {
+		if _, ok := budgetInEffect(budgets, month); ok {
+			seen[id] = true
+		}
+	}
+	for k := range assigned {
+		if k.month == month {
+			seen[k.categoryID] = true
+		}
+	}
+	for id := range carry {
+		seen[id] = true
+	}
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// rollUpEnvelopes merges the envelopes of categories below depth into their
+// ancestor at depth. A merged envelope has the rollover of the ancestor's
+// own envelope, or none when the ancestor has no envelope itself.
+func rollUpEnvelopes(envelopes []LedgerEnvelope, depth int, parents map[int]*int, names map[int]string) []LedgerEnvelope {
+	merged := map[int]*LedgerEnvelope{}
+	var order []int
+	for _, e := range envelopes {
+		top := categoryAt(e.CategoryID, depth, parents)
+		m := merged[top]
+		if m == nil {
+			m = &LedgerEnvelope{CategoryID: top, CategoryName: names[top]}
+			merged[top] = m
+			order = append(order, top)
+		}
+		if e.CategoryID == top {
+			m.Rollover = e.Rollover
+		}
+		m.CarriedIn += e.CarriedIn
+		m.Budgeted += e.Budgeted
+		m.Assigned += e.Assigned
+		m.Spent += e.Spent
+		m.Available += e.Available
+		m.CarriedOut += e.CarriedOut
+	}
+	rolled := make([]LedgerEnvelope, 0, len(order))
+	for _, id := range order {
+		rolled = append(rolled, *merged[id])
+	}
+	return rolled
+}
+
+// budgetInEffect returns the last of budgets, oldest first, that started by
+// month
+func budgetInEffect(budgets []Budget, month string) (Budget, bool) {
+	for i := len(budgets) - 1; i >= 0; i-- {
+		if budgets[i].StartDate[:7] <= month {
+			return budgets[i], true
+		}
+	}
+	return Budget{}, false
+}
+
+// carriedOut is the part of an envelope's available balance that rollover
+// takes into the next month
+func carriedOut(available float64, rollover string) float64 {
+	switch rollover {
+	case rolloverUnused:
+		return max(available, 0)
+	case rolloverOverspend:
+		return min(available, 0)
+	case rolloverBoth:
+		return available
+	default:
+		return 0
+	}
+}
+
+func roundEnvelope(e LedgerEnvelope) LedgerEnvelope {
+	e.CarriedIn = roundCents(e.CarriedIn)
+	e.Budgeted = roundCents(e.Budgeted)
+	e.Assigned = roundCents(e.Assigned)
+	e.Spent = roundCents(e.Spent)
+	e.Available = roundCents(e.Available)
+	e.CarriedOut = roundCents(e.CarriedOut)
+	return e
+}
+
+func roundCents(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// currentMonth returns this month in UTC, formatted as YYYY-MM
+func currentMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+// validMonth reports whether s is a month formatted as YYYY-MM
+func validMonth(s string) bool {
+	_, err := time.Parse("2006-01", s)
+	return err == nil
+}
+
+// addMonths returns the month n months after month, both YYYY-MM
+func addMonths(month string, n int) string {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return month
+	}
+	return t.AddDate(0, n, 0).Format("2006-01")
+}
+
+// monthsBetween returns how many months to is after from
+func monthsBetween(from, to string) int {
+	f, _ := time.Parse("2006-01", from)
+	t, _ := time.Parse("2006-01", to)
+	return (t.Year()-f.Year())*12 + int(t.Month()-f.Month())
+}
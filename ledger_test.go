@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+)
+
+var ledgerCategories = []Category{
+	{ID: 1, Name: "Groceries", Type: "expense"},
+	{ID: 2, Name: "Rent", Type: "expense"},
+	{ID: 6, Name: "Salary", Type: "income"},
+	{ID: 8, Name: "Dining", Type: "expense", ParentID: intPtr(1)},
+	{ID: 9, Name: "Restaurants", Type: "expense", ParentID: intPtr(8)},
+}
+
+func intPtr(n int) *int { return &n }
+
+func spending(month string, categoryID int, total float64) MonthlyTotal {
+	return MonthlyTotal{Month: month, CategoryID: &categoryID, Type: "expense", Total: total}
+}
+
+func earning(month string, total float64) MonthlyTotal {
+	return MonthlyTotal{Month: month, CategoryID: intPtr(6), Type: "income", Total: total}
+}
+
+// envelopeIn returns the envelope of categoryID in month of l
+func envelopeIn(t *testing.T, l Ledger, month string, categoryID int) LedgerEnvelope {
+	t.Helper()
+	for _, m := range l.Months {
+		if m.Month != month {
+			continue
+		}
+		for _, e := range m.Envelopes {
+			if e.CategoryID == categoryID {
+				return e
+			}
+		}
+	}
+	t.Fatalf("no envelope for category %d in %s: %+v", categoryID, month, l.Months)
+	return LedgerEnvelope{}
+}
+
+func TestLedgerRollover(t *testing.T) {
+	tests := []struct {
+		rollover string
+		spent    float64
+		carried  float64
+	}{
+		{rolloverNone, 70, 0},
+		{rolloverNone, 130, 0},
+		{rolloverUnused, 70, 30},
+		{rolloverUnused, 130, 0},
+		{rolloverOverspend, 70, 0},
+		{rolloverOverspend, 130, -30},
+		{rolloverBoth, 70, 30},
+		{rolloverBoth, 130, -30},
+	}
+	for _, tt := range tests {
+		budgets := []Budget{{ID: 1, CategoryID: 1, Amount: 100, StartDate: "2026-01-01", Rollover: tt.rollover}}
+		totals := []MonthlyTotal{spending("2026-01", 1, tt.spent)}
+		l := buildLedger("2026-01", "2026-02", 0, budgets, nil, totals, ledgerCategories)
+
+		jan := envelopeIn(t, l, "2026-01", 1)
+		if jan.Available != 100-tt.spent || jan.CarriedOut != tt.carried {
+			t.Errorf("%s spending %v: January %+v, want carried_out %v", tt.rollover, tt.spent, jan, tt.carried)
+		}
+		feb := envelopeIn(t, l, "2026-02", 1)
+		if feb.CarriedIn != tt.carried || feb.Available != 100+tt.carried {
+			t.Errorf("%s spending %v: February %+v, want carried_in %v", tt.rollover, tt.spent, feb, tt.carried)
+		}
+	}
+}
+
+func TestLedgerBudgetChangesRollover(t *testing.T) {
+	budgets := []Budget{
+		{ID: 2, CategoryID: 1, Amount: 200, StartDate: "2026-02-01", Rollover: rolloverNone},
+		{ID: 1, CategoryID: 1, Amount: 100, StartDate: "2026-01-01", Rollover: rolloverUnused},
+	}
+	l := buildLedger("2026-01", "2026-03", 0, budgets, nil, []MonthlyTotal{spending("2026-01", 1, 40)}, ledgerCategories)
+
+	if feb := envelopeIn(t, l, "2026-02", 1); feb.CarriedIn != 60 || feb.Budgeted != 200 || feb.CarriedOut != 0 {
+		t.Errorf("February %+v, want 60 carried in under the January budget and nothing carried out", feb)
+	}
+	if mar := envelopeIn(t, l, "2026-03", 1); mar.CarriedIn != 0 || mar.Available != 200 {
+		t.Errorf("March %+v, want a fresh 200", mar)
+	}
+}
+
+func TestLedgerAllocations(t *testing.T) {
+	allocations := []Allocation{
+		{Month: "2026-01", CategoryID: 2, Amount: 50},
+		{Month: "2026-02", CategoryID: 2, Amount: 25},
+	}
+	totals := []MonthlyTotal{
+		earning("2025-12", 1000),
+		earning("2026-01", 500),
+		spending("2026-01", 2, 20),
+	}
+	l := buildLedger("2026-01", "2026-02", 0, nil, allocations, totals, ledgerCategories)
+
+	// Without a budget, what was assigned and not spent is kept
+	rent := envelopeIn(t, l, "2026-02", 2)
+	if rent.Rollover != rolloverUnused || rent.CarriedIn != 30 || rent.Assigned != 25 || rent.Available != 55 {
+		t.Errorf("February rent %+v, want 30 carried in and 25 assigned", rent)
+	}
+	// Income from before the first allocation is ready to assign too
+	if jan := l.Months[0]; jan.Income != 500 || jan.Assigned != 50 || jan.ReadyToAssign != 1450 {
+		t.Errorf("January %+v, want 1450 ready to assign", jan)
+	}
+	if feb := l.Months[1]; feb.ReadyToAssign != 1425 {
+		t.Errorf("February ready to assign = %v, want 1425", feb.ReadyToAssign)
+	}
+}
+
+func TestLedgerSubcategorySpending(t *testing.T) {
+	budgets := []Budget{
+		{ID: 1, CategoryID: 1, Amount: 300, StartDate: "2026-01-01", Rollover: rolloverNone},
+		{ID: 2, CategoryID: 8, Amount: 100, StartDate: "2026-01-01", Rollover: rolloverUnused},
+	}
+	totals := []MonthlyTotal{
+		spending("2026-01", 1, 50),
+		spending("2026-01", 8, 30),
+		// Restaurants has no envelope; Dining is its nearest ancestor with one
+		spending("2026-01", 9, 40),
+	}
+
+	l := buildLedger("2026-01", "2026-01", 0, budgets, nil, totals, ledgerCategories)
+	if groceries := envelopeIn(t, l, "2026-01", 1); groceries.Spent != 50 {
+		t.Errorf("groceries spent %v, want 50: subcategories with an envelope keep their spending", groceries.Spent)
+	}
+	if dining := envelopeIn(t, l, "2026-01", 8); dining.Spent != 70 || dining.CarriedOut != 30 {
+		t.Errorf("dining %+v, want 70 spent including restaurants", dining)
+	}
+
+	// depth=1 merges Dining into Groceries, which keeps its own rollover
+	l = buildLedger("2026-01", "2026-01", 1, budgets, nil, totals, ledgerCategories)
+	if n := len(l.Months[0].Envelopes); n != 1 {
+		t.Fatalf("depth=1 has %d envelopes, want 1", n)
+	}
+	merged := envelopeIn(t, l, "2026-01", 1)
+	want := LedgerEnvelope{CategoryID: 1, CategoryName: "Groceries", Rollover: rolloverNone,
+		Budgeted: 400, Spent: 120, Available: 280, CarriedOut: 30}
+	if merged != want {
+		t.Errorf("depth=1 envelope %+v, want %+v", merged, want)
+	}
+}
+
+func TestLedgerReplayIsBounded(t *testing.T) {
+	budgets := []Budget{{ID: 1, CategoryID: 1, Amount: 100, StartDate: "1900-01-01", Rollover: rolloverUnused}}
+	allocations := []Allocation{{Month: "1900-02", CategoryID: 2, Amount: 40}}
+	totals := []MonthlyTotal{earning("1900-01", 100)}
+
+	l := buildLedger("2026-01", "2026-02", 0, budgets, allocations, totals, ledgerCategories)
+	if len(l.Months) != 2 {
+		t.Fatalf("got %d months, want 2", len(l.Months))
+	}
+	// Ten years of unused budget carried in, not 126
+	if jan := envelopeIn(t, l, "2026-01", 1); jan.CarriedIn != 100*(ledgerMaxReplayMonths-2) {
+		t.Errorf("January carried in %v, want %v", jan.CarriedIn, 100*(ledgerMaxReplayMonths-2))
+	}
+	if ready := l.Months[0].ReadyToAssign; ready != 60 {
+		t.Errorf("ready to assign = %v, want income less assignments from before the replay", ready)
+	}
+}
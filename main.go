@@ -1,81 +1,261 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
-	// Check for migrate command
-	migrateCmd := flag.Bool("migrate", false, "Run database migration and seed data")
 	seedDemoCmd := flag.Bool("seed-demo", false, "Seed demo transactions and budgets (idempotent)")
+	createOwnerCmd := flag.String("create-owner", "", "Create an owner member with the given name and print its API token")
+	printConfigCmd := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	configFlags := bindConfigFlags(flag.CommandLine)
 	flag.Parse()
 
-	if *migrateCmd {
-		if err := setupDatabase(); err != nil {
-			log.Fatalf("Migration failed: %v", err)
+	cfg, err := loadConfig(configFlags)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	if err := initLogging(cfg.Log); err != nil {
+		fatal("Failed to initialize logging", "error", err)
+	}
+	if *printConfigCmd {
+		if err := printConfig(cfg); err != nil {
+			fatal("Printing configuration failed", "error", err)
 		}
-		log.Println("Migration completed successfully")
+		os.Exit(0)
+	}
+
+	// Check for migrate command
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrateCommand(cfg, flag.Args()[1:]); err != nil {
+			fatal("Migration failed", "error", err)
+		}
+		slog.Info("Migration completed successfully")
 		os.Exit(0)
 	}
 	if *seedDemoCmd {
-		if err := initDB(); err != nil {
-			log.Fatalf("Failed to initialize database: %v", err)
+		db, d, err := initDB(cfg)
+		if err != nil {
+			fatal("Failed to initialize database", "error", err)
 		}
 		defer db.Close()
-		if err := seedDemoData(db); err != nil {
-			log.Fatalf("Seeding demo data failed: %v", err)
+		if err := seedDemoData(db, d); err != nil {
+			fatal("Seeding demo data failed", "error", err)
+		}
+		slog.Info("Demo data seeded")
+		os.Exit(0)
+	}
+	if *createOwnerCmd != "" {
+		store, err := openStore(cfg)
+		if err != nil {
+			fatal("Failed to initialize database", "error", err)
+		}
+		defer store.Close()
+		token, err := createOwner(context.Background(), store, *createOwnerCmd)
+		if err != nil {
+			fatal("Creating owner failed", "error", err)
 		}
-		log.Println("Demo data seeded")
+		fmt.Println(token)
 		os.Exit(0)
 	}
+	shutdownTracing, err := initTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to initialize tracing", "error", err)
+	}
+
 	// Initialize database
-	if err := initDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	store, err := openStore(cfg)
+	if err != nil {
+		fatal("Failed to initialize database", "error", err)
 	}
-	defer db.Close()
 
-	// Initialize Redis
-	if err := initRedis(); err != nil {
-		log.Printf("Warning: Failed to initialize Redis: %v", err)
-		log.Println("Continuing without Redis cache...")
-		redisClient = nil
+	server := &Server{store: store, cfg: cfg, metrics: newMetrics(store)}
+	bg := newWorkers()
+	var redisClient *redis.Client
+	if _, ok := store.(*memoryStore); ok {
+		// Nothing survives a restart, so bootstrap an owner every time
+		token, err := createOwner(context.Background(), store, "Owner")
+		if err != nil {
+			fatal("Creating owner failed", "error", err)
+		}
+		slog.Info("In-memory owner token", "token", token)
+		server.cache = server.metrics.instrument(newMemoryCache(cfg.Cache.LocalMaxEntries))
+	} else if redisClient, err = initRedis(cfg.RedisURL); err != nil {
+		slog.Warn("Failed to initialize Redis; caching in process only", "error", err)
+		server.cache = server.metrics.instrument(newMemoryCache(cfg.Cache.LocalMaxEntries))
+	} else {
+		tiered := newTieredCache(newMemoryCache(cfg.Cache.LocalMaxEntries), newRedisCache(redisClient, cfg.Cache), cfg.Cache.LocalTTL)
+		server.cache = server.metrics.instrument(tiered)
+		bg.Go("redis-reconnect", func(ctx context.Context) {
+			tiered.reconnect(ctx, cfg.Cache.ReconnectMaxBackoff)
+		})
+		bg.Go("cache-invalidation", func(ctx context.Context) {
+			tiered.subscribeInvalidations(ctx, cfg.Cache.ReconnectMaxBackoff)
+		})
+	}
+	server.events = newEventBroker(redisClient, cfg.Cache.Timeout, cfg.Events.History)
+	server.webhooks = newWebhookDispatcher(store, cfg.Webhooks, server.metrics)
+	server.outbox = newOutboxRelay(store, cfg.Outbox, server.deliverEvent)
+	server.channels = newNotificationChannels(cfg.Alerts)
+	if redisClient != nil {
+		bg.Go("events-subscribe", func(ctx context.Context) {
+			server.events.subscribeEvents(ctx, cfg.Cache.ReconnectMaxBackoff)
+		})
 	}
 
-	// Setup Gin router
-	r := gin.Default()
+	bg.Go("idempotency-purge", func(ctx context.Context) {
+		purgeIdempotencyKeys(ctx, store, time.Hour)
+	})
+	bg.Go("outbox-relay", server.outbox.run)
+	bg.Go("outbox-purge", func(ctx context.Context) {
+		purgeOutbox(ctx, store, time.Hour, cfg.Outbox.Retention)
+	})
+	bg.Go("budget-alerts", func(ctx context.Context) {
+		server.evaluateBudgets(ctx, cfg.Alerts.Interval)
+	})
+	bg.Go("webhook-dispatcher", server.webhooks.run)
+	bg.Go("webhook-purge", func(ctx context.Context) {
+		purgeWebhookDeliveries(ctx, store, time.Hour, cfg.Webhooks.Retention)
+	})
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           newRouter(server),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	// Event streams never finish by themselves
+	srv.RegisterOnShutdown(server.events.close)
+
+	// Start server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting", "port", cfg.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		slog.Error("Failed to start server", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		slog.Info("Shutdown signal received, draining connections")
+	}
+	stop()
+
+	// Shut down in dependency order: stop taking requests and let in-flight
+	// ones finish, stop background workers, close the DB and Redis, then
+	// flush the remaining spans.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("HTTP server did not drain in time", "error", err)
+	}
+	if err := bg.Stop(shutdownCtx); err != nil {
+		slog.Warn("Background workers did not stop in time", "error", err)
+	}
+	if err := store.Close(); err != nil {
+		slog.Warn("Closing database failed", "error", err)
+	}
+	if redisClient != nil {
+		if err := redisClient.Close(); err != nil {
+			slog.Warn("Closing Redis failed", "error", err)
+		}
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("Flushing traces failed", "error", err)
+	}
+	slog.Info("Server stopped")
+	os.Exit(exitCode)
+}
+
+// newRouter sets up the Gin router with middleware and every route
+func newRouter(s *Server) *gin.Engine {
+	r := gin.New()
+	r.Use(requestID(), queryToken())
+	r.Use(otelgin.Middleware(s.cfg.Tracing.ServiceName, otelgin.WithFilter(traceRequest)))
+	r.Use(s.metrics.middleware())
+	r.Use(accessLog(), recovery())
+	r.Use(s.requestTimeout())
 
 	// CORS middleware
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowOrigins:     s.cfg.CORS.AllowOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "traceparent", "tracestate", requestIDHeader, idempotencyKeyHeader, "If-Match", "If-None-Match", "Last-Event-ID"},
+		ExposeHeaders:    []string{"Content-Length", requestIDHeader, "Idempotent-Replayed", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	useJSONFieldNames()
+	r.NoRoute(func(c *gin.Context) { respondError(c, notFound("no such endpoint")) })
+
 	// Routes
-	r.GET("/health", healthCheck)
-	r.GET("/api/transactions", getTransactions)
-	r.POST("/api/transactions", addTransaction)
-	r.DELETE("/api/transactions/:id", deleteTransaction)
-	r.GET("/api/categories", getCategories)
-	r.GET("/api/analytics", getAnalytics)
+	r.GET("/health", s.healthCheck)
+	r.GET("/livez", s.livez)
+	r.GET("/readyz", s.readyz)
+	r.GET("/metrics", s.metrics.handler())
+	r.POST("/api/invitations/accept", s.acceptInvitation)
+	r.GET("/api/events", s.authenticate(), require(PermViewTransactions), s.streamEvents)
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	api := r.Group("/api", s.authenticate())
+	api.GET("/transactions", require(PermViewTransactions), s.getTransactions)
+	api.POST("/transactions", require(PermAddTransaction), s.idempotent(), s.addTransaction)
+	api.GET("/transactions/:id", require(PermViewTransactions), s.getTransaction)
+	api.PUT("/transactions/:id", require(PermEditOwnTransaction), s.updateTransaction)
+	api.DELETE("/transactions/:id", require(PermDeleteOwnTransaction), s.deleteTransaction)
+	api.GET("/categories", require(PermViewCategories), s.getCategories)
+	api.POST("/categories", require(PermManageCategories), s.createCategory)
+	api.GET("/categories/:id", require(PermViewCategories), s.getCategory)
+	api.PUT("/categories/:id", require(PermManageCategories), s.updateCategory)
+	api.GET("/analytics", require(PermViewAnalytics), s.getAnalytics)
+	api.GET("/budgets", require(PermViewAnalytics), s.listBudgets)
+	api.POST("/budgets", require(PermManageBudgets), s.createBudget)
+	api.GET("/budgets/ledger", require(PermViewAnalytics), s.getLedger)
+	api.PUT("/budgets/allocations/:month/:category_id", require(PermManageBudgets), s.setAllocation)
+	api.GET("/budgets/:id", require(PermViewAnalytics), s.getBudget)
+	api.PUT("/budgets/:id", require(PermManageBudgets), s.updateBudget)
+	api.DELETE("/budgets/:id", require(PermManageBudgets), s.deleteBudget)
+	api.GET("/goals", require(PermViewAnalytics), s.listGoals)
+	api.POST("/goals", require(PermManageBudgets), s.createGoal)
+	api.GET("/goals/:id", require(PermViewAnalytics), s.getGoal)
+	api.PUT("/goals/:id", require(PermManageBudgets), s.updateGoal)
+	api.DELETE("/goals/:id", require(PermManageBudgets), s.deleteGoal)
+	api.GET("/members", require(PermViewMembers), s.listMembers)
+	api.PUT("/members/:id/role", require(PermManageMembers), s.updateMemberRole)
+	api.DELETE("/members/:id", require(PermManageMembers), s.removeMember)
+	api.GET("/invitations", require(PermManageMembers), s.listInvitations)
+	api.POST("/invitations", require(PermManageMembers), s.createInvitation)
+	api.DELETE("/invitations/:id", require(PermManageMembers), s.revokeInvitation)
+	api.GET("/webhooks", require(PermManageWebhooks), s.listWebhooks)
+	api.POST("/webhooks", require(PermManageWebhooks), s.createWebhook)
+	api.GET("/webhooks/:id", require(PermManageWebhooks), s.getWebhook)
+	api.DELETE("/webhooks/:id", require(PermManageWebhooks), s.deleteWebhook)
+	api.GET("/webhooks/:id/deliveries", require(PermManageWebhooks), s.listWebhookDeliveries)
+	api.POST("/webhooks/:id/test", require(PermManageWebhooks), s.testWebhook)
+	api.GET("/notifications", require(PermViewAnalytics), s.listNotifications)
+	api.GET("/notifications/unread_count", require(PermViewAnalytics), s.countUnreadNotifications)
+	api.POST("/notifications/read", require(PermViewAnalytics), s.readAllNotifications)
+	api.POST("/notifications/:id/read", require(PermViewAnalytics), s.readNotification)
 
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
+	return r
 }
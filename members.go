@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invitationTTL is how long an invitation token can be accepted
+const invitationTTL = 7 * 24 * time.Hour
+
+// listMembers retrieves all household members
+func (s *Server) listMembers(c *gin.Context) {
+	members, err := s.store.ListMembers(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// updateMemberRole changes a member's role
+func (s *Server) updateMemberRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid member id"))
+		return
+	}
+
+	var req struct {
+		Role Role `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+	if !req.Role.valid() {
+		respondError(c, invalidField("role", "must be one of: owner, editor, contributor, viewer"))
+		return
+	}
+
+	m, err := s.store.UpdateMemberRole(c.Request.Context(), id, req.Role)
+	if errors.Is(err, errNotFound) {
+		respondError(c, notFound("member not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, memberWriteError(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, m)
+}
+
+// memberWriteError explains the store errors of a member write
+func memberWriteError(err error) error {
+	if errors.Is(err, errLastOwner) {
+		return conflict(errLastOwner.Error())
+	}
+	return err
+}
+
+// removeMember revokes a member's access
+func (s *Server) removeMember(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid member id"))
+		return
+	}
+
+	if err := s.store.DeleteMember(c.Request.Context(), id); err != nil {
+		respondError(c, memberWriteError(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// listInvitations retrieves pending and accepted invitations
+func (s *Server) listInvitations(c *gin.Context) {
+	invitations, err := s.store.ListInvitations(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// createInvitation issues an invitation token for a new member. The token is
+// only returned in this response.
+func (s *Server) createInvitation(c *gin.Context) {
+	var req struct {
+		Email *string `json:"email"`
+		Role  Role    `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+	if !req.Role.valid() {
+		respondError(c, invalidField("role", "must be one of: owner, editor, contributor, viewer"))
+		return
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	inv, err := s.store.CreateInvitation(c.Request.Context(), Invitation{
+		Email:     req.Email,
+		Role:      req.Role,
+		InvitedBy: &currentMember(c).ID,
+	}, hash, time.Now().Add(invitationTTL))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	inv.Token = token
+
+	c.JSON(http.StatusCreated, inv)
+}
+
+// revokeInvitation deletes an invitation that has not been accepted yet
+func (s *Server) revokeInvitation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid invitation id"))
+		return
+	}
+
+	err = s.store.DeletePendingInvitation(c.Request.Context(), id)
+	if errors.Is(err, errNotFound) {
+		respondError(c, notFound("invitation not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+// acceptInvitation turns an invitation token into a member and returns the
+// member's API token. This route is not authenticated.
+func (s *Server) acceptInvitation(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+		Name  string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	m, err := s.store.AcceptInvitation(c.Request.Context(), hashToken(req.Token), req.Name, hash)
+	if errors.Is(err, errNotFound) {
+		respondError(c, notFound("invitation is invalid or expired"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"member": m, "token": token})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors exposed on /metrics. They live in
+// their own registry rather than the global default one.
+type metrics struct {
+	registry            *prometheus.Registry
+	httpRequests        *prometheus.CounterVec
+	httpDuration        *prometheus.HistogramVec
+	cacheRequests       *prometheus.CounterVec
+	transactionsCreated *prometheus.CounterVec
+	webhookDeliveries   *prometheus.CounterVec
+}
+
+func newMetrics(store Store) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_requests_total",
+			Help: "Cache lookups by key and result (hit or miss).",
+		}, []string{"key", "result"}),
+		transactionsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "finance_transactions_created_total",
+			Help: "Transactions created through the API by type.",
+		}, []string{"type"}),
+		webhookDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhook_delivery_attempts_total",
+			Help: "Webhook delivery attempts by outcome (succeeded, retrying or failed).",
+		}, []string{"outcome"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.cacheRequests,
+		m.transactionsCreated,
+		m.webhookDeliveries,
+	)
+	if s, ok := store.(*sqlStore); ok {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(s.db.DB, s.dialect.name))
+	}
+	return m
+}
+
+// handler serves the registry in the Prometheus text format
+func (m *metrics) handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// middleware records the count and latency of every request. Routes are
+// labelled with their pattern (/api/transactions/:id) to keep cardinality low.
+func (m *metrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		m.httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// transactionCreated counts a new transaction. Types other than income and
+// expense share one label value since the type is not validated.
+func (m *metrics) transactionCreated(txType string) {
+	if txType != "income" && txType != "expense" {
+		txType = "other"
+	}
+	m.transactionsCreated.WithLabelValues(txType).Inc()
+}
+
+// webhookDelivered counts a webhook delivery attempt
+func (m *metrics) webhookDelivered(outcome string) {
+	m.webhookDeliveries.WithLabelValues(outcome).Inc()
+}
+
+// instrumentedCache counts hits and misses of the wrapped cache
+type instrumentedCache struct {
+	Cache
+	requests *prometheus.CounterVec
+}
+
+// instrument wraps c so that its lookups are counted
+func (m *metrics) instrument(c Cache) Cache {
+	return &instrumentedCache{Cache: c, requests: m.cacheRequests}
+}
+
+func (c *instrumentedCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	data, ok := c.Cache.Get(ctx, key)
+	result := "miss"
+	if ok {
+		result = "hit"
+	}
+	c.requests.WithLabelValues(cacheKeyLabel(key), result).Inc()
+	return data, ok
+}
+
+// cacheKeyLabel reduces a key to its name, dropping anything after the first
+// colon, so parameterised keys do not create a series each
+func cacheKeyLabel(key string) string {
+	name, _, _ := strings.Cut(key, ":")
+	return name
+}
@@ -0,0 +1,3 @@
+ALTER TABLE transactions DROP COLUMN IF EXISTS created_by;
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS members;
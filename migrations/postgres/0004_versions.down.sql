@@ -0,0 +1,2 @@
+ALTER TABLE categories DROP COLUMN IF EXISTS version;
+ALTER TABLE transactions DROP COLUMN IF EXISTS version;
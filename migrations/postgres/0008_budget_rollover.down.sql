@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS budget_allocations;
+ALTER TABLE budgets DROP COLUMN IF EXISTS version;
+ALTER TABLE budgets DROP COLUMN IF EXISTS rollover;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_categories_parent;
+ALTER TABLE categories DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS categories (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name VARCHAR(100) NOT NULL,
+	type VARCHAR(20) NOT NULL,
+	color VARCHAR(7) DEFAULT '#667eea',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	date DATE NOT NULL,
+	description VARCHAR(255) NOT NULL,
+	amount DECIMAL(10,2) NOT NULL,
+	category_id INTEGER REFERENCES categories(id),
+	type VARCHAR(20) NOT NULL,
+	notes TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS budgets (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	category_id INTEGER REFERENCES categories(id),
+	amount DECIMAL(10,2) NOT NULL,
+	period VARCHAR(20) DEFAULT 'monthly',
+	start_date DATE NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_name_type ON categories(name, type);
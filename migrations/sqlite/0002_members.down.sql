@@ -0,0 +1,18 @@
+-- SQLite cannot drop a column that is part of a foreign key, so rebuild the table
+CREATE TABLE transactions_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	date DATE NOT NULL,
+	description VARCHAR(255) NOT NULL,
+	amount DECIMAL(10,2) NOT NULL,
+	category_id INTEGER REFERENCES categories(id),
+	type VARCHAR(20) NOT NULL,
+	notes TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO transactions_old (id, date, description, amount, category_id, type, notes, created_at)
+	SELECT id, date, description, amount, category_id, type, notes, created_at FROM transactions;
+DROP TABLE transactions;
+ALTER TABLE transactions_old RENAME TO transactions;
+
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS members;
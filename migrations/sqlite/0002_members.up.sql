@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS members (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name VARCHAR(100) NOT NULL,
+	email VARCHAR(255),
+	role VARCHAR(20) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS invitations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email VARCHAR(255),
+	role VARCHAR(20) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	invited_by INTEGER REFERENCES members(id) ON DELETE SET NULL,
+	expires_at TIMESTAMP NOT NULL,
+	accepted_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE transactions ADD COLUMN created_by INTEGER REFERENCES members(id) ON DELETE SET NULL;
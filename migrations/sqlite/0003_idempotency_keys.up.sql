@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	member_id INTEGER NOT NULL REFERENCES members(id) ON DELETE CASCADE,
+	idempotency_key VARCHAR(255) NOT NULL,
+	request_hash VARCHAR(64) NOT NULL,
+	status_code INTEGER,
+	response_body BLOB,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (member_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
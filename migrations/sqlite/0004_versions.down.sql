@@ -0,0 +1,2 @@
+ALTER TABLE categories DROP COLUMN version;
+ALTER TABLE transactions DROP COLUMN version;
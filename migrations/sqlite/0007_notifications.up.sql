@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS notifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type VARCHAR(50) NOT NULL,
+	message TEXT NOT NULL,
+	budget_id INTEGER REFERENCES budgets(id) ON DELETE CASCADE,
+	category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL,
+	threshold INTEGER,
+	amount DECIMAL(10,2),
+	spent DECIMAL(10,2),
+	period_start DATE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A budget alerts once per period and threshold
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_budget_alert ON notifications(budget_id, period_start, threshold);
+
+CREATE TABLE IF NOT EXISTS notification_reads (
+	notification_id INTEGER NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+	member_id INTEGER NOT NULL REFERENCES members(id) ON DELETE CASCADE,
+	read_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (notification_id, member_id)
+);
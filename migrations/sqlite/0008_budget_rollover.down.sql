@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS budget_allocations;
+ALTER TABLE budgets DROP COLUMN version;
+ALTER TABLE budgets DROP COLUMN rollover;
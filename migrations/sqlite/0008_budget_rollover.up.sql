@@ -0,0 +1,11 @@
+ALTER TABLE budgets ADD COLUMN rollover VARCHAR(20) NOT NULL DEFAULT 'none';
+ALTER TABLE budgets ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS budget_allocations (
+	month VARCHAR(7) NOT NULL,
+	category_id INTEGER NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+	amount DECIMAL(10,2) NOT NULL,
+	updated_by INTEGER REFERENCES members(id) ON DELETE SET NULL,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (month, category_id)
+);
@@ -0,0 +1,14 @@
+-- A goal tracks the transactions in category_id and its subcategories, or
+-- those tagged #tag; exactly one of the two is set
+CREATE TABLE IF NOT EXISTS goals (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name VARCHAR(100) NOT NULL,
+	target_amount DECIMAL(10,2) NOT NULL,
+	target_date DATE NOT NULL,
+	start_date DATE NOT NULL,
+	category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL,
+	tag VARCHAR(50),
+	created_by INTEGER REFERENCES members(id) ON DELETE SET NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -1,28 +1,44 @@
 package main
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // Transaction represents a financial transaction
 type Transaction struct {
 	ID            int     `json:"id"`
-	Date          string  `json:"date"`
-	Description   string  `json:"description"`
-	Amount        float64 `json:"amount"`
+	Date          string  `json:"date" binding:"required,datetime=2006-01-02"`
+	Description   string  `json:"description" binding:"required"`
+	Amount        float64 `json:"amount" binding:"required,gt=0"`
 	CategoryID    *int    `json:"category_id"`
-	Type          string  `json:"type"`
+	Type          string  `json:"type" binding:"required,oneof=income expense"`
 	Notes         *string `json:"notes"`
+	CreatedBy     *int    `json:"created_by"`
+	Version       int     `json:"version"`
 	CreatedAt     string  `json:"created_at"`
 	CategoryName  *string `json:"category_name"`
 	CategoryColor *string `json:"category_color"`
 }
 
-// Category represents a transaction category
+// Category represents a transaction category. Categories form a tree: a
+// subcategory has the ParentID of a category of the same type.
 type Category struct {
 	ID        int    `json:"id"`
-	Name      string `json:"name"`
-	Type      string `json:"type"`
-	Color     string `json:"color"`
+	Name      string `json:"name" binding:"required"`
+	Type      string `json:"type" binding:"required,oneof=income expense"`
+	Color     string `json:"color" binding:"required"`
+	ParentID  *int   `json:"parent_id"`
+	Version   int    `json:"version"`
 	CreatedAt string `json:"created_at"`
 }
 
+// CategoryNode is a category with its subcategories
+type CategoryNode struct {
+	Category
+	Children []CategoryNode `json:"children"`
+}
+
 // AnalyticsSummary contains summary statistics for analytics
 type AnalyticsSummary struct {
 	TotalIncome      float64 `json:"total_income"`
@@ -32,13 +48,219 @@ type AnalyticsSummary struct {
 
 // CategoryAnalytics contains analytics data for a specific category
 type CategoryAnalytics struct {
-	Name  string  `json:"name"`
-	Color string  `json:"color"`
-	Total float64 `json:"total"`
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Color    string  `json:"color"`
+	ParentID *int    `json:"parent_id"`
+	Total    float64 `json:"total"`
 }
 
 // Analytics contains all analytics data
 type Analytics struct {
 	Summary    AnalyticsSummary    `json:"summary"`
 	ByCategory []CategoryAnalytics `json:"byCategory"`
+	Goals      []Goal              `json:"goals"`
+}
+
+// Member is a person with access to the household's dashboard
+type Member struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	Email     *string `json:"email"`
+	Role      Role    `json:"role"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// Invitation lets someone join the household with a given role
+type Invitation struct {
+	ID         int     `json:"id"`
+	Email      *string `json:"email"`
+	Role       Role    `json:"role"`
+	InvitedBy  *int    `json:"invited_by"`
+	ExpiresAt  string  `json:"expires_at"`
+	AcceptedAt *string `json:"accepted_at"`
+	CreatedAt  string  `json:"created_at"`
+	Token      string  `json:"token,omitempty"`
+}
+
+// Webhook posts events of the subscribed types to an external URL
+type Webhook struct {
+	ID     int      `json:"id"`
+	URL    string   `json:"url" binding:"required,http_url"`
+	Events []string `json:"events" binding:"required,min=1,dive,oneof=transaction.created transaction.updated transaction.deleted category.created category.updated budget.updated budget.deleted budget.assigned goal.created goal.updated goal.deleted budget.threshold budget.exceeded"`
+	// Secret signs deliveries. One is generated unless given; it is only
+	// returned when the webhook is created.
+	Secret    string `json:"secret,omitempty" binding:"omitempty,min=16"`
+	CreatedBy *int   `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// WebhookDelivery is one event queued for, or delivered to, a webhook
+type WebhookDelivery struct {
+	ID        int             `json:"id"`
+	WebhookID int             `json:"webhook_id"`
+	EventID   string          `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	// Status is pending until the receiver accepts the delivery (succeeded)
+	// or every attempt has failed (failed)
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	ResponseStatus *int      `json:"response_status"`
+	LastError      *string   `json:"last_error"`
+	CreatedAt      string    `json:"created_at"`
+	DeliveredAt    *string   `json:"delivered_at"`
+}
+
+// Budget rollover options: what happens to a budget's balance at the end of
+// a month
+const (
+	rolloverNone      = "none"      // every month starts afresh
+	rolloverUnused    = "unused"    // unspent money carries forward
+	rolloverOverspend = "overspend" // overspending carries forward as debt
+	rolloverBoth      = "both"      // both carry forward
+)
+
+// Budget is a monthly spending limit for a category, in effect from the
+// month of StartDate until a budget with a later StartDate replaces it
+type Budget struct {
+	ID           int     `json:"id"`
+	CategoryID   int     `json:"category_id" binding:"required"`
+	CategoryName string  `json:"category_name"`
+	Amount       float64 `json:"amount" binding:"required,gt=0"`
+	Period       string  `json:"period" binding:"omitempty,oneof=monthly"`
+	StartDate    string  `json:"start_date" binding:"required,datetime=2006-01-02"`
+	Rollover     string  `json:"rollover" binding:"omitempty,oneof=none unused overspend both"`
+	Version      int     `json:"version"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+// Allocation is money from income assigned to a category's envelope for a
+// month
+type Allocation struct {
+	Month      string  `json:"month"`
+	CategoryID int     `json:"category_id"`
+	Amount     float64 `json:"amount" binding:"gte=0"`
+	UpdatedBy  *int    `json:"updated_by"`
+	UpdatedAt  string  `json:"updated_at"`
+}
+
+// MonthlyTotal is the income or expense total of a category in a month;
+// CategoryID is nil for uncategorized transactions
+type MonthlyTotal struct {
+	Month      string
+	CategoryID *int
+	Type       string
+	Total      float64
+}
+
+// Ledger shows month by month how money was assigned and how each envelope
+// evolved
+type Ledger struct {
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	Months []LedgerMonth `json:"months"`
+}
+
+// LedgerMonth is one month of the ledger
+type LedgerMonth struct {
+	Month    string  `json:"month"`
+	Income   float64 `json:"income"`
+	Assigned float64 `json:"assigned"`
+	// ReadyToAssign is all income up to the end of the month less everything
+	// assigned up to then
+	ReadyToAssign float64          `json:"ready_to_assign"`
+	Envelopes     []LedgerEnvelope `json:"envelopes"`
+}
+
+// LedgerEnvelope is one category's envelope in a month. Available is
+// CarriedIn + Budgeted + Assigned - Spent; CarriedOut is the part of it the
+// budget's rollover takes into the next month. Rollover is empty for an
+// envelope merged from subcategories when the category has none itself.
+type LedgerEnvelope struct {
+	CategoryID   int     `json:"category_id"`
+	CategoryName string  `json:"category_name"`
+	Rollover     string  `json:"rollover,omitempty"`
+	CarriedIn    float64 `json:"carried_in"`
+	Budgeted     float64 `json:"budgeted"`
+	Assigned     float64 `json:"assigned"`
+	Spent        float64 `json:"spent"`
+	Available    float64 `json:"available"`
+	CarriedOut   float64 `json:"carried_out"`
+}
+
+// Goal statuses
+const (
+	goalAchieved = "achieved" // saved at least the target
+	goalOnTrack  = "on_track" // saved at least as much as a steady pace would have by now
+	goalBehind   = "behind"
+)
+
+// Goal is a savings target. Money saved towards it is the income less the
+// expenses from StartDate on in CategoryID and its subcategories, or tagged
+// #Tag in their description or notes.
+type Goal struct {
+	ID           int          `json:"id"`
+	Name         string       `json:"name" binding:"required,max=100"`
+	TargetAmount float64      `json:"target_amount" binding:"required,gt=0"`
+	TargetDate   string       `json:"target_date" binding:"required,datetime=2006-01-02"`
+	StartDate    string       `json:"start_date" binding:"omitempty,datetime=2006-01-02"`
+	CategoryID   *int         `json:"category_id" binding:"required_without=Tag,excluded_with=Tag"`
+	Tag          *string      `json:"tag" binding:"omitempty,max=50"`
+	CreatedBy    *int         `json:"created_by"`
+	Version      int          `json:"version"`
+	CreatedAt    string       `json:"created_at"`
+	Progress     GoalProgress `json:"progress"`
+}
+
+// GoalProgress is how far a goal has come and what it still takes
+type GoalProgress struct {
+	Saved     float64 `json:"saved"`
+	Remaining float64 `json:"remaining"`
+	Percent   int     `json:"percent"`
+	// Expected is what saving steadily from StartDate to TargetDate would
+	// have put aside by today
+	Expected float64 `json:"expected"`
+	// RequiredMonthly is what must be saved in each of MonthsLeft, this
+	// month and the target date's included, to reach the target
+	RequiredMonthly float64 `json:"required_monthly"`
+	MonthsLeft      int     `json:"months_left"`
+	Status          string  `json:"status"`
+}
+
+// BudgetUsage is a category's budget and what has been spent against it in
+// a month. CarriedIn is the balance the category's envelope brought in from
+// earlier months, negative when it was overspent.
+type BudgetUsage struct {
+	BudgetID     int     `json:"budget_id"`
+	CategoryID   int     `json:"category_id"`
+	CategoryName string  `json:"category_name"`
+	Amount       float64 `json:"amount"`
+	CarriedIn    float64 `json:"carried_in"`
+	Spent        float64 `json:"spent"`
+	Period       string  `json:"period"`
+}
+
+// available is what the budget allows to spend in its month: the budgeted
+// amount plus the balance carried in
+func (u BudgetUsage) available() float64 {
+	return u.Amount + u.CarriedIn
+}
+
+// Notification is an alert shown to household members, such as a budget
+// reaching a threshold
+type Notification struct {
+	ID          int      `json:"id"`
+	Type        string   `json:"type"`
+	Message     string   `json:"message"`
+	BudgetID    *int     `json:"budget_id"`
+	CategoryID  *int     `json:"category_id"`
+	Threshold   *int     `json:"threshold"`
+	Amount      *float64 `json:"amount"`
+	Spent       *float64 `json:"spent"`
+	PeriodStart *string  `json:"period_start"`
+	CreatedAt   string   `json:"created_at"`
+	// ReadAt is when the current member marked the notification read
+	ReadAt *string `json:"read_at"`
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notificationChannel delivers notifications outside the API, e.g. by email.
+// Channels are called by the outbox relay, once per alert on a best effort
+// basis: a failed send is logged, not retried.
+type notificationChannel interface {
+	name() string
+	send(ctx context.Context, n Notification) error
+}
+
+// newNotificationChannels returns the channels named in cfg.Channels
+func newNotificationChannels(cfg AlertsConfig) []notificationChannel {
+	var channels []notificationChannel
+	for _, name := range cfg.Channels {
+		switch name {
+		case "log":
+			channels = append(channels, logChannel{})
+		case "email":
+			channels = append(channels, emailChannel{cfg: cfg.Email})
+		}
+	}
+	return channels
+}
+
+// notify sends n to every channel
+func (s *Server) notify(ctx context.Context, n Notification) {
+	for _, ch := range s.channels {
+		if err := ch.send(ctx, n); err != nil {
+			slog.WarnContext(ctx, "Sending notification failed", "channel", ch.name(), "notification_id", n.ID, "error", err)
+		}
+	}
+}
+
+// logChannel writes notifications to the service log
+type logChannel struct{}
+
+func (logChannel) name() string { return "log" }
+
+func (logChannel) send(ctx context.Context, n Notification) error {
+	slog.InfoContext(ctx, "Notification", "notification_id", n.ID, "type", n.Type, "message", n.Message)
+	return nil
+}
+
+// emailTimeout bounds sending one email
+const emailTimeout = 30 * time.Second
+
+// emailChannel mails notifications through an SMTP server
+type emailChannel struct {
+	cfg EmailConfig
+}
+
+func (emailChannel) name() string { return "email" }
+
+func (ch emailChannel) send(ctx context.Context, n Notification) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", ch.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(ch.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Message))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(n.Message + "\r\n")
+
+	host, _, err := net.SplitHostPort(ch.cfg.SMTPAddr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, emailTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", ch.cfg.SMTPAddr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if ch.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", ch.cfg.Username, ch.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(ch.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range ch.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// listNotifications retrieves notifications newest first; ?unread=true
+// leaves out those the current member has read
+func (s *Server) listNotifications(c *gin.Context) {
+	unread, err := strconv.ParseBool(c.DefaultQuery("unread", "false"))
+	if err != nil {
+		respondError(c, invalidField("unread", "must be true or false"))
+		return
+	}
+	limit := 50
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			respondError(c, invalidField("limit", "must be between 1 and 500"))
+			return
+		}
+		limit = n
+	}
+
+	notifications, err := s.store.ListNotifications(c.Request.Context(), currentMember(c).ID, unread, limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+// countUnreadNotifications returns how many notifications the current
+// member has not read
+func (s *Server) countUnreadNotifications(c *gin.Context) {
+	n, err := s.store.CountUnreadNotifications(c.Request.Context(), currentMember(c).ID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread": n})
+}
+
+// readNotification marks a notification read for the current member
+func (s *Server) readNotification(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid notification id"))
+		return
+	}
+
+	n, err := s.store.MarkNotificationRead(c.Request.Context(), currentMember(c).ID, id)
+	if errors.Is(err, errNotFound) {
+		respondError(c, notFound("notification not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, n)
+}
+
+// readAllNotifications marks every notification read for the current member
+func (s *Server) readAllNotifications(c *gin.Context) {
+	n, err := s.store.MarkAllNotificationsRead(c.Request.Context(), currentMember(c).ID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": n})
+}
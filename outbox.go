@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	outboxBatchSize = 50
+	// outboxLease is how long a claimed entry is hidden from other relays
+	outboxLease = 30 * time.Second
+	// outboxRetryBackoff doubles after every failed attempt, up to
+	// outboxMaxBackoff
+	outboxRetryBackoff = time.Second
+	outboxMaxBackoff   = time.Minute
+)
+
+// OutboxEntry is an event waiting in the outbox
+type OutboxEntry struct {
+	ID       int64
+	Event    Event
+	Attempts int
+}
+
+// outboxRelay publishes the events written to the outbox along with the
+// changes they describe. An event is relayed at least once: if a replica dies
+// between publishing an event and marking it published, another relays it
+// again once the lease runs out.
+type outboxRelay struct {
+	store   OutboxStore
+	cfg     OutboxConfig
+	deliver func(ctx context.Context, e Event) error
+	wake    chan struct{}
+}
+
+func newOutboxRelay(store OutboxStore, cfg OutboxConfig, deliver func(ctx context.Context, e Event) error) *outboxRelay {
+	return &outboxRelay{store: store, cfg: cfg, deliver: deliver, wake: make(chan struct{}, 1)}
+}
+
+// nudge makes run look at the outbox now rather than at the next poll
+func (r *outboxRelay) nudge() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run relays due events until ctx ends
+func (r *outboxRelay) run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+		for r.relayDue(ctx) > 0 {
+			// Relaying an event may have unblocked the next of its aggregate
+		}
+	}
+}
+
+// relayDue relays one batch of due events and returns its size. A batch
+// holds at most one event per aggregate, so relaying it in order keeps
+// every aggregate's events in order.
+func (r *outboxRelay) relayDue(ctx context.Context) int {
+	now := time.Now()
+	due, err := r.store.ClaimOutbox(ctx, now, now.Add(outboxLease), outboxBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("Claiming outbox events failed", "error", err)
+		}
+		return 0
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	for _, entry := range due {
+		if err := r.deliver(ctx, entry.Event); err != nil {
+			entry.Attempts++
+			slog.Warn("Relaying event failed; it will be retried",
+				"event_id", entry.Event.ID, "type", entry.Event.Type, "attempts", entry.Attempts, "error", err)
+			next := time.Now().Add(outboxBackoff(entry.Attempts))
+			if err := r.store.RetryOutbox(ctx, entry.ID, entry.Attempts, next, err.Error()); err != nil {
+				slog.Warn("Recording outbox retry failed", "event_id", entry.Event.ID, "error", err)
+			}
+			continue
+		}
+		if err := r.store.MarkOutboxPublished(ctx, entry.ID); err != nil {
+			slog.Warn("Marking event published failed; it will be relayed again",
+				"event_id", entry.Event.ID, "error", err)
+		}
+	}
+	return len(due)
+}
+
+// outboxBackoff is the wait before the next attempt after attempts failed
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxRetryBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
+
+// deliverEvent invalidates what e makes stale, then hands it to dashboards,
+// webhooks and, for alerts, the notification channels. Handlers already
+// bump cache versions for read-your-writes; bumping again here makes sure
+// the bump is not lost with the request.
+func (s *Server) deliverEvent(ctx context.Context, e Event) error {
+	switch {
+	case strings.HasPrefix(e.Type, "transaction."):
+		s.bumpVersion(ctx, nsTransactions)
+	case strings.HasPrefix(e.Type, "category."):
+		s.bumpVersion(ctx, nsCategories)
+	case strings.HasPrefix(e.Type, "goal."):
+		s.bumpVersion(ctx, nsGoals)
+	}
+	s.events.publish(ctx, e)
+	if err := s.webhooks.enqueue(ctx, e); err != nil {
+		return err
+	}
+	if n, ok := notificationFromEvent(e); ok {
+		s.notify(ctx, n)
+	}
+	return nil
+}
+
+// recordEvents writes events that do not come with a change to the outbox
+func (s *Server) recordEvents(ctx context.Context, events ...Event) {
+	if err := s.store.AppendOutbox(ctx, events...); err != nil {
+		slog.ErrorContext(ctx, "Recording events failed", "error", err)
+		return
+	}
+	s.outbox.nudge()
+}
+
+func purgeOutbox(ctx context.Context, store OutboxStore, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.PurgeOutbox(ctx, time.Now().Add(-retention))
+			if err != nil {
+				slog.Warn("Purging the outbox failed", "error", err)
+			} else if n > 0 {
+				slog.Info("Purged published outbox events", "count", n)
+			}
+		}
+	}
+}
@@ -2,22 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"net"
 	"time"
 
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
-var redisClient *redis.Client
-
-// initRedis initializes the Redis connection
-func initRedis() error {
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
-		redisURL = "redis:6379"
-	}
-
+// initRedis creates the Redis client. It does not wait for Redis to be up:
+// the client connects on first use and the cache reconnects by itself.
+func initRedis(redisURL string) (*redis.Client, error) {
 	opt, err := redis.ParseURL(fmt.Sprintf("redis://%s", redisURL))
 	if err != nil {
 		// Fallback to simple connection
@@ -26,14 +23,91 @@ func initRedis() error {
 		}
 	}
 
-	redisClient = redis.NewClient(opt)
+	client := redis.NewClient(opt)
+	if err := redisotel.InstrumentTracing(client); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to instrument redis: %w", err)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	return client, nil
+}
 
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		return fmt.Errorf("failed to connect to redis: %w", err)
+// subscriptionPingInterval is how long a subscription may stay silent
+// before it is pinged, so a dead connection is noticed even without traffic
+const subscriptionPingInterval = 15 * time.Second
+
+// subscription keeps a Redis pub/sub channel subscribed and hands each
+// message to onMessage until ctx ends. Messages published while it is down
+// are lost, so onState is told every time the subscription comes up or
+// goes down. Lost connections are retried with backoff up to maxBackoff.
+type subscription struct {
+	client     *redis.Client
+	channel    string
+	timeout    time.Duration // of the subscribe and ping round trips
+	maxBackoff time.Duration
+	onState    func(up bool)
+	onMessage  func(payload string)
+}
+
+func (s subscription) run(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		subscribed, err := s.listen(ctx)
+		if subscribed {
+			s.onState(false)
+			delay = minReconnectDelay
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Redis subscription lost; resubscribing", "channel", s.channel, "error", err, "retry_in", delay.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, s.maxBackoff)
+	}
+}
+
+// listen holds one subscription until it fails. It reports whether the
+// subscription was established before failing.
+func (s subscription) listen(ctx context.Context) (bool, error) {
+	ps := s.client.Subscribe(ctx, s.channel)
+	defer ps.Close()
+	// Reads are not interrupted by ctx; closing the subscription is
+	stop := context.AfterFunc(ctx, func() { ps.Close() })
+	defer stop()
+
+	if _, err := ps.ReceiveTimeout(ctx, s.timeout+subscriptionPingInterval); err != nil {
+		return false, err
 	}
+	s.onState(true)
+	slog.Info("Subscribed to Redis channel", "channel", s.channel)
 
-	return nil
+	pinged := false
+	for {
+		msg, err := ps.ReceiveTimeout(ctx, subscriptionPingInterval)
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout():
+			if pinged {
+				return true, errors.New("no answer to ping")
+			}
+			pingCtx, cancel := context.WithTimeout(ctx, s.timeout)
+			err = ps.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return true, err
+			}
+			pinged = true
+		case err != nil:
+			return true, err
+		default:
+			pinged = false
+			if m, ok := msg.(*redis.Message); ok {
+				s.onMessage(m.Payload)
+			}
+		}
+	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// timedDB wraps *sql.DB so that statements slower than slow are logged.
+// When tx is set, statements run in that transaction instead.
+type timedDB struct {
+	*sql.DB
+	slow time.Duration
+	tx   *sql.Tx
+}
+
+func (db timedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer logSlowQuery(ctx, db.slow, time.Now(), query)
+	if db.tx != nil {
+		return db.tx.QueryContext(ctx, query, args...)
+	}
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+func (db timedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer logSlowQuery(ctx, db.slow, time.Now(), query)
+	if db.tx != nil {
+		return db.tx.QueryRowContext(ctx, query, args...)
+	}
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (db timedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer logSlowQuery(ctx, db.slow, time.Now(), query)
+	if db.tx != nil {
+		return db.tx.ExecContext(ctx, query, args...)
+	}
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+func (db timedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (timedTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	return timedTx{Tx: tx, slow: db.slow}, err
+}
+
+// timedTx is the transaction counterpart of timedDB
+type timedTx struct {
+	*sql.Tx
+	slow time.Duration
+}
+
+func (tx timedTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer logSlowQuery(ctx, tx.slow, time.Now(), query)
+	return tx.Tx.QueryContext(ctx, query, args...)
+}
+
+func (tx timedTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer logSlowQuery(ctx, tx.slow, time.Now(), query)
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
+
+func (tx timedTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer logSlowQuery(ctx, tx.slow, time.Now(), query)
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
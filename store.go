@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errNotFound is returned by stores when the requested row does not exist
+var errNotFound = errors.New("not found")
+
+// errVersionMismatch is returned by conditional writes when the row was
+// changed since the expected version was read
+var errVersionMismatch = errors.New("version mismatch")
+
+// errCategoryCycle is returned by UpdateCategory when the new parent is the
+// category itself or one of its subcategories
+var errCategoryCycle = errors.New("category would be its own ancestor")
+
+// errLastOwner is returned by member writes that would leave the household
+// without an owner
+var errLastOwner = errors.New("household must keep at least one owner")
+
+// TransactionStore persists transactions
+type TransactionStore interface {
+	ListTransactions(ctx context.Context, limit int) ([]Transaction, error)
+	GetTransaction(ctx context.Context, id int) (Transaction, error)
+	// The writes record the events about the change in the outbox, in the
+	// same transaction; events are built from the stored row.
+	CreateTransaction(ctx context.Context, t Transaction, events func(Transaction) []Event) (Transaction, error)
+	// UpdateTransaction and DeleteTransaction only write when the row is
+	// still at expectedVersion; zero writes unconditionally.
+	UpdateTransaction(ctx context.Context, t Transaction, expectedVersion int, events func(Transaction) []Event) (Transaction, error)
+	// DeleteTransaction only records events when a row was deleted
+	DeleteTransaction(ctx context.Context, id, expectedVersion int, events []Event) error
+}
+
+// CategoryStore persists transaction categories
+type CategoryStore interface {
+	ListCategories(ctx context.Context) ([]Category, error)
+	GetCategory(ctx context.Context, id int) (Category, error)
+	// CreateCategory records the events about the new category in the
+	// outbox, like the transaction writes
+	CreateCategory(ctx context.Context, c Category, events func(Category) []Event) (Category, error)
+	// UpdateCategory only writes when the row is still at expectedVersion;
+	// zero writes unconditionally. It returns errCategoryCycle rather than
+	// make a category its own ancestor.
+	UpdateCategory(ctx context.Context, c Category, expectedVersion int, events func(Category) []Event) (Category, error)
+}
+
+// AnalyticsStore computes analytics over stored transactions
+type AnalyticsStore interface {
+	// Analytics attributes spending to categories at most depth levels
+	// down the category tree; zero keeps every category apart.
+	Analytics(ctx context.Context, depth int) (Analytics, error)
+}
+
+// MemberStore persists household members and their invitations. Tokens are
+// only ever passed in hashed form.
+type MemberStore interface {
+	MemberByTokenHash(ctx context.Context, tokenHash string) (Member, error)
+	ListMembers(ctx context.Context) ([]Member, error)
+	CreateMember(ctx context.Context, m Member, tokenHash string) (Member, error)
+	// UpdateMemberRole and DeleteMember return errLastOwner rather than
+	// demote or remove the only owner, checking in the same transaction as
+	// the write.
+	UpdateMemberRole(ctx context.Context, id int, role Role) (Member, error)
+	DeleteMember(ctx context.Context, id int) error
+	ListInvitations(ctx context.Context) ([]Invitation, error)
+	CreateInvitation(ctx context.Context, inv Invitation, tokenHash string, expiresAt time.Time) (Invitation, error)
+	DeletePendingInvitation(ctx context.Context, id int) error
+	// AcceptInvitation marks a pending, unexpired invitation as accepted and
+	// creates the member it was issued for, returning errNotFound otherwise.
+	AcceptInvitation(ctx context.Context, tokenHash, name, memberTokenHash string) (Member, error)
+}
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key. StatusCode is zero while the first request is running.
+type IdempotencyRecord struct {
+	RequestHash string    `json:"request_hash"`
+	StatusCode  int       `json:"status_code"`
+	Body        []byte    `json:"body"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// IdempotencyStore persists idempotency keys, scoped per member
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey claims key for a new request and reports true,
+	// or returns the existing record and false. Expired keys, and keys still
+	// in progress that were created before staleBefore, are replaced.
+	ReserveIdempotencyKey(ctx context.Context, memberID int, key string, rec IdempotencyRecord, now, staleBefore time.Time) (IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, memberID int, key string, statusCode int, body []byte) error
+	// ReleaseIdempotencyKey forgets an in-progress key so it can be retried
+	ReleaseIdempotencyKey(ctx context.Context, memberID int, key string) error
+	PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Webhook delivery statuses
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+// WebhookStore persists webhooks and their delivery queue
+type WebhookStore interface {
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	// GetWebhook returns the webhook including its secret
+	GetWebhook(ctx context.Context, id int) (Webhook, error)
+	CreateWebhook(ctx context.Context, w Webhook) (Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	CreateWebhookDelivery(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error)
+	// ClaimWebhookDeliveries returns up to limit pending deliveries due at
+	// now and pushes their next attempt back to leaseUntil, so that other
+	// replicas skip them while they are attempted, and retry them should this
+	// one die before recording the outcome.
+	ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]WebhookDelivery, error)
+	// RecordWebhookAttempt stores the status, attempts, next attempt and
+	// response of d after an attempt
+	RecordWebhookAttempt(ctx context.Context, d WebhookDelivery) error
+	// ListWebhookDeliveries returns a webhook's deliveries, newest first
+	ListWebhookDeliveries(ctx context.Context, webhookID, limit int) ([]WebhookDelivery, error)
+	// PurgeWebhookDeliveries deletes finished deliveries created before before
+	PurgeWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// BudgetStore persists budgets and envelope allocations
+type BudgetStore interface {
+	ListBudgets(ctx context.Context) ([]Budget, error)
+	GetBudget(ctx context.Context, id int) (Budget, error)
+	// CreateBudget, UpdateBudget and DeleteBudget record the events about
+	// the change in the outbox, like the transaction writes. UpdateBudget
+	// and DeleteBudget only write when the row is still at expectedVersion
+	// (0 skips the check).
+	CreateBudget(ctx context.Context, b Budget, events func(Budget) []Event) (Budget, error)
+	UpdateBudget(ctx context.Context, b Budget, expectedVersion int, events func(Budget) []Event) (Budget, error)
+	DeleteBudget(ctx context.Context, id, expectedVersion int, events []Event) error
+	// ListAllocations returns the allocations of months up to through
+	// (YYYY-MM)
+	ListAllocations(ctx context.Context, through string) ([]Allocation, error)
+	// SetAllocation creates or replaces the allocation of a month and
+	// category
+	SetAllocation(ctx context.Context, a Allocation, events func(Allocation) []Event) (Allocation, error)
+	// MonthlyTotals returns income and expense totals per month and category
+	// for the months up to through (YYYY-MM)
+	MonthlyTotals(ctx context.Context, through string) ([]MonthlyTotal, error)
+	// BudgetUsage returns the budget that spending in a category counts
+	// towards in month (YYYY-MM), with that month's spending, or
+	// errNotFound when there is none. Spending counts towards the budget of
+	// the category or, failing that, of its nearest ancestor with one. Only
+	// budgets in effect in month count, as in budgetInEffect. CarriedIn is
+	// left to the caller.
+	BudgetUsage(ctx context.Context, categoryID int, month string) (BudgetUsage, error)
+	// ListBudgetUsage returns the BudgetUsage of every category with a
+	// budget in effect in month
+	ListBudgetUsage(ctx context.Context, month string) ([]BudgetUsage, error)
+}
+
+// GoalStore persists savings goals
+type GoalStore interface {
+	ListGoals(ctx context.Context) ([]Goal, error)
+	GetGoal(ctx context.Context, id int) (Goal, error)
+	// CreateGoal, UpdateGoal and DeleteGoal record the events about the
+	// change in the outbox, like the budget writes
+	CreateGoal(ctx context.Context, g Goal, events func(Goal) []Event) (Goal, error)
+	UpdateGoal(ctx context.Context, g Goal, expectedVersion int, events func(Goal) []Event) (Goal, error)
+	DeleteGoal(ctx context.Context, id, expectedVersion int, events []Event) error
+	// GoalsSaved returns what has been saved towards goal goalID, or every
+	// goal when it is zero, by goal ID: of the transactions that count
+	// towards a goal, dated from its start date up to through (YYYY-MM-DD),
+	// income adds to it and expenses take from it. For a goal on an expense
+	// category it is the other way round. A goal with nothing saved may be
+	// missing from the map.
+	GoalsSaved(ctx context.Context, through string, goalID int) (map[int]float64, error)
+}
+
+// NotificationStore persists notifications and who has read them
+type NotificationStore interface {
+	// CreateBudgetAlert stores a budget alert and records its events in the
+	// outbox, unless the budget already alerted at n.Threshold or higher
+	// in n.PeriodStart's period; then it returns errConflict.
+	CreateBudgetAlert(ctx context.Context, n Notification, events func(Notification) []Event) (Notification, error)
+	// ListNotifications returns notifications newest first, with ReadAt as
+	// seen by memberID
+	ListNotifications(ctx context.Context, memberID int, unreadOnly bool, limit int) ([]Notification, error)
+	CountUnreadNotifications(ctx context.Context, memberID int) (int, error)
+	MarkNotificationRead(ctx context.Context, memberID, id int) (Notification, error)
+	// MarkAllNotificationsRead returns how many notifications it marked
+	MarkAllNotificationsRead(ctx context.Context, memberID int) (int64, error)
+}
+
+// OutboxStore persists events until they are relayed
+type OutboxStore interface {
+	// AppendOutbox records events that do not come with a write
+	AppendOutbox(ctx context.Context, events ...Event) error
+	// ClaimOutbox returns up to limit unpublished entries due at now, in
+	// order, and pushes their next attempt back to leaseUntil. Only the
+	// oldest unpublished entry of an aggregate is ever claimed, so the
+	// events of an aggregate are relayed one at a time and in order.
+	ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxEntry, error)
+	MarkOutboxPublished(ctx context.Context, id int64) error
+	// RetryOutbox records a failed attempt to relay an entry
+	RetryOutbox(ctx context.Context, id int64, attempts int, next time.Time, lastErr string) error
+	// PurgeOutbox deletes entries published before before
+	PurgeOutbox(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Store bundles every store the handlers need
+type Store interface {
+	TransactionStore
+	CategoryStore
+	AnalyticsStore
+	MemberStore
+	IdempotencyStore
+	WebhookStore
+	BudgetStore
+	NotificationStore
+	GoalStore
+	OutboxStore
+	Ping(ctx context.Context) error
+	// SchemaVersion returns the applied and the latest known migration
+	// versions; both are zero for stores without migrations.
+	SchemaVersion(ctx context.Context) (applied, latest int, err error)
+	Close() error
+}
@@ -0,0 +1,1168 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore implements Store in process memory. It backs the memory://
+// DATABASE_URL and keeps the handlers usable without a database server.
+type memoryStore struct {
+	mu            sync.Mutex
+	transactions  []Transaction
+	categories    []Category
+	members       []memoryMember
+	invitations   []memoryInvitation
+	idempotency   map[memoryIdempotencyKey]memoryIdempotency
+	webhooks      []Webhook
+	deliveries    []memoryDelivery
+	outbox        []memoryOutboxEntry
+	notifications []memoryNotification
+	budgets       []Budget
+	allocations   []Allocation
+	goals         []Goal
+	nextID        int
+	now           func() time.Time
+}
+
+type memoryMember struct {
+	Member
+	tokenHash string
+}
+
+type memoryInvitation struct {
+	Invitation
+	tokenHash string
+	expiresAt time.Time
+}
+
+type memoryDelivery struct {
+	WebhookDelivery
+	createdAt time.Time
+}
+
+type memoryOutboxEntry struct {
+	OutboxEntry
+	nextAttemptAt time.Time
+	publishedAt   *time.Time
+}
+
+type memoryNotification struct {
+	Notification
+	readAt map[int]string // by member ID
+}
+
+type memoryIdempotencyKey struct {
+	memberID int
+	key      string
+}
+
+type memoryIdempotency struct {
+	IdempotencyRecord
+	createdAt time.Time
+}
+
+// newMemoryStore returns an empty store seeded with the default categories
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{now: time.Now, idempotency: map[memoryIdempotencyKey]memoryIdempotency{}}
+	for _, c := range defaultCategories {
+		s.categories = append(s.categories, Category{
+			ID: s.id(), Name: c.Name, Type: c.Type, Color: c.Color, Version: 1, CreatedAt: s.timestamp(),
+		})
+	}
+	return s
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) SchemaVersion(ctx context.Context) (int, int, error) {
+	return 0, 0, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *memoryStore) timestamp() string {
+	return s.now().UTC().Format(time.RFC3339)
+}
+
+func (s *memoryStore) categoryByID(id *int) *Category {
+	if id == nil {
+		return nil
+	}
+	for i := range s.categories {
+		if s.categories[i].ID == *id {
+			return &s.categories[i]
+		}
+	}
+	return nil
+}
+
+// withCategory fills in the joined category columns like the SQL stores do
+func (s *memoryStore) withCategory(t Transaction) Transaction {
+	t.CategoryName, t.CategoryColor = nil, nil
+	if c := s.categoryByID(t.CategoryID); c != nil {
+		name, color := c.Name, c.Color
+		t.CategoryName, t.CategoryColor = &name, &color
+	}
+	return t
+}
+
+func (s *memoryStore) ListTransactions(ctx context.Context, limit int) ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transactions := make([]Transaction, 0, len(s.transactions))
+	for _, t := range s.transactions {
+		transactions = append(transactions, s.withCategory(t))
+	}
+	sort.SliceStable(transactions, func(i, j int) bool { return transactions[i].Date > transactions[j].Date })
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+	}
+	return transactions, nil
+}
+
+func (s *memoryStore) GetTransaction(ctx context.Context, id int) (Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.transactions {
+		if t.ID == id {
+			return s.withCategory(t), nil
+		}
+	}
+	return Transaction{}, errNotFound
+}
+
+func (s *memoryStore) CreateTransaction(ctx context.Context, t Transaction, events func(Transaction) []Event) (Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.CategoryName, t.CategoryColor = nil, nil
+	if t.CategoryID != nil && s.categoryByID(t.CategoryID) == nil {
+		return Transaction{}, errInvalidReference
+	}
+	t.ID = s.id()
+	t.Version = 1
+	t.CreatedAt = s.timestamp()
+	s.transactions = append(s.transactions, t)
+	s.appendOutbox(events(t))
+	return t, nil
+}
+
+func (s *memoryStore) UpdateTransaction(ctx context.Context, t Transaction, expectedVersion int, events func(Transaction) []Event) (Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.transactions {
+		cur := &s.transactions[i]
+		if cur.ID != t.ID {
+			continue
+		}
+		if expectedVersion != 0 && cur.Version != expectedVersion {
+			return Transaction{}, errVersionMismatch
+		}
+		if t.CategoryID != nil && s.categoryByID(t.CategoryID) == nil {
+			return Transaction{}, errInvalidReference
+		}
+		cur.Date, cur.Description, cur.Amount = t.Date, t.Description, t.Amount
+		cur.CategoryID, cur.Type, cur.Notes = t.CategoryID, t.Type, t.Notes
+		cur.Version++
+		result := s.withCategory(*cur)
+		s.appendOutbox(events(result))
+		return result, nil
+	}
+	return Transaction{}, errNotFound
+}
+
+func (s *memoryStore) DeleteTransaction(ctx context.Context, id, expectedVersion int, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, t := range s.transactions {
+		if t.ID == id {
+			if expectedVersion != 0 && t.Version != expectedVersion {
+				return errVersionMismatch
+			}
+			s.transactions = append(s.transactions[:i], s.transactions[i+1:]...)
+			s.appendOutbox(events)
+			return nil
+		}
+	}
+	if expectedVersion != 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (s *memoryStore) ListCategories(ctx context.Context) ([]Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	categories := append([]Category(nil), s.categories...)
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories, nil
+}
+
+func (s *memoryStore) GetCategory(ctx context.Context, id int) (Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c := s.categoryByID(&id); c != nil {
+		return *c, nil
+	}
+	return Category{}, errNotFound
+}
+
+func (s *memoryStore) CreateCategory(ctx context.Context, c Category, events func(Category) []Event) (Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.categories {
+		if other.Name == c.Name && other.Type == c.Type {
+			return Category{}, errConflict
+		}
+	}
+	if c.ParentID != nil && s.categoryByID(c.ParentID) == nil {
+		return Category{}, errInvalidReference
+	}
+	c.ID = s.id()
+	c.Version = 1
+	c.CreatedAt = s.timestamp()
+	s.categories = append(s.categories, c)
+	s.appendOutbox(events(c))
+	return c, nil
+}
+
+func (s *memoryStore) UpdateCategory(ctx context.Context, c Category, expectedVersion int, events func(Category) []Event) (Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur := s.categoryByID(&c.ID)
+	if cur == nil {
+		return Category{}, errNotFound
+	}
+	if expectedVersion != 0 && cur.Version != expectedVersion {
+		return Category{}, errVersionMismatch
+	}
+	for _, other := range s.categories {
+		if other.ID != c.ID && other.Name == c.Name && other.Type == c.Type {
+			return Category{}, errConflict
+		}
+	}
+	if c.ParentID != nil {
+		if s.categoryByID(c.ParentID) == nil {
+			return Category{}, errInvalidReference
+		}
+		if slices.Contains(categoryPath(*c.ParentID, categoryParents(s.categories)), c.ID) {
+			return Category{}, errCategoryCycle
+		}
+	}
+	cur.Name, cur.Type, cur.Color, cur.ParentID = c.Name, c.Type, c.Color, c.ParentID
+	cur.Version++
+	s.appendOutbox(events(*cur))
+	return *cur, nil
+}
+
+func (s *memoryStore) Analytics(ctx context.Context, depth int) (Analytics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	since := s.now().AddDate(0, 0, -30).Format(time.DateOnly)
+	var summary AnalyticsSummary
+	parents := categoryParents(s.categories)
+	totals := map[int]float64{}
+	for _, t := range s.transactions {
+		if t.Date < since {
+			continue
+		}
+		summary.TransactionCount++
+		switch t.Type {
+		case "income":
+			summary.TotalIncome += t.Amount
+		case "expense":
+			summary.TotalExpenses += t.Amount
+			if s.categoryByID(t.CategoryID) != nil {
+				totals[categoryAt(*t.CategoryID, depth, parents)] += t.Amount
+			}
+		}
+	}
+
+	byCategory := make([]CategoryAnalytics, 0, len(totals))
+	for id, total := range totals {
+		c := s.categoryByID(&id)
+		byCategory = append(byCategory, CategoryAnalytics{ID: c.ID, Name: c.Name, Color: c.Color, ParentID: c.ParentID, Total: total})
+	}
+	sort.Slice(byCategory, func(i, j int) bool { return byCategory[i].Total > byCategory[j].Total })
+
+	return Analytics{Summary: summary, ByCategory: byCategory}, nil
+}
+
+func (s *memoryStore) MemberByTokenHash(ctx context.Context, tokenHash string) (Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.members {
+		if m.tokenHash == tokenHash {
+			return m.Member, nil
+		}
+	}
+	return Member{}, errNotFound
+}
+
+func (s *memoryStore) ListMembers(ctx context.Context) ([]Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := make([]Member, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, m.Member)
+	}
+	return members, nil
+}
+
+func (s *memoryStore) CreateMember(ctx context.Context, m Member, tokenHash string) (Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createMember(m, tokenHash), nil
+}
+
+func (s *memoryStore) createMember(m Member, tokenHash string) Member {
+	m.ID = s.id()
+	m.CreatedAt = s.timestamp()
+	s.members = append(s.members, memoryMember{Member: m, tokenHash: tokenHash})
+	return m
+}
+
+func (s *memoryStore) UpdateMemberRole(ctx context.Context, id int, role Role) (Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if role != RoleOwner && s.isLastOwner(id) {
+		return Member{}, errLastOwner
+	}
+	for i := range s.members {
+		if s.members[i].ID == id {
+			s.members[i].Role = role
+			return s.members[i].Member, nil
+		}
+	}
+	return Member{}, errNotFound
+}
+
+func (s *memoryStore) DeleteMember(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isLastOwner(id) {
+		return errLastOwner
+	}
+	for i, m := range s.members {
+		if m.ID == id {
+			s.members = append(s.members[:i], s.members[i+1:]...)
+			break
+		}
+	}
+	for i := range s.transactions {
+		if s.transactions[i].CreatedBy != nil && *s.transactions[i].CreatedBy == id {
+			s.transactions[i].CreatedBy = nil
+		}
+	}
+	return nil
+}
+
+// isLastOwner reports whether member id is the only owner; the caller holds
+// s.mu
+func (s *memoryStore) isLastOwner(id int) bool {
+	owners, isOwner := 0, false
+	for _, m := range s.members {
+		if m.Role == RoleOwner {
+			owners++
+			isOwner = isOwner || m.ID == id
+		}
+	}
+	return isOwner && owners == 1
+}
+
+func (s *memoryStore) ListInvitations(ctx context.Context) ([]Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitations := make([]Invitation, 0, len(s.invitations))
+	for i := len(s.invitations) - 1; i >= 0; i-- {
+		invitations = append(invitations, s.invitations[i].Invitation)
+	}
+	return invitations, nil
+}
+
+func (s *memoryStore) CreateInvitation(ctx context.Context, inv Invitation, tokenHash string, expiresAt time.Time) (Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv.ID = s.id()
+	inv.CreatedAt = s.timestamp()
+	inv.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	inv.AcceptedAt = nil
+	s.invitations = append(s.invitations, memoryInvitation{Invitation: inv, tokenHash: tokenHash, expiresAt: expiresAt})
+	return inv, nil
+}
+
+func (s *memoryStore) DeletePendingInvitation(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, inv := range s.invitations {
+		if inv.ID == id && inv.AcceptedAt == nil {
+			s.invitations = append(s.invitations[:i], s.invitations[i+1:]...)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (s *memoryStore) AcceptInvitation(ctx context.Context, tokenHash, name, memberTokenHash string) (Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.invitations {
+		inv := &s.invitations[i]
+		if inv.tokenHash != tokenHash || inv.AcceptedAt != nil || !s.now().Before(inv.expiresAt) {
+			continue
+		}
+		accepted := s.timestamp()
+		inv.AcceptedAt = &accepted
+		return s.createMember(Member{Name: name, Email: inv.Email, Role: inv.Role}, memberTokenHash), nil
+	}
+	return Member{}, errNotFound
+}
+
+func (s *memoryStore) ReserveIdempotencyKey(ctx context.Context, memberID int, key string, rec IdempotencyRecord, now, staleBefore time.Time) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoryIdempotencyKey{memberID, key}
+	existing, ok := s.idempotency[k]
+	if ok && !existing.ExpiresAt.After(now) {
+		ok = false
+	}
+	if ok && existing.StatusCode == 0 && existing.createdAt.Before(staleBefore) {
+		ok = false
+	}
+	if ok {
+		return existing.IdempotencyRecord, false, nil
+	}
+	s.idempotency[k] = memoryIdempotency{IdempotencyRecord: rec, createdAt: now}
+	return rec, true, nil
+}
+
+func (s *memoryStore) CompleteIdempotencyKey(ctx context.Context, memberID int, key string, statusCode int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoryIdempotencyKey{memberID, key}
+	if e, ok := s.idempotency[k]; ok {
+		e.StatusCode, e.Body = statusCode, body
+		s.idempotency[k] = e
+	}
+	return nil
+}
+
+func (s *memoryStore) ReleaseIdempotencyKey(ctx context.Context, memberID int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := memoryIdempotencyKey{memberID, key}
+	if e, ok := s.idempotency[k]; ok && e.StatusCode == 0 {
+		delete(s.idempotency, k)
+	}
+	return nil
+}
+
+func (s *memoryStore) PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for k, e := range s.idempotency {
+		if !e.ExpiresAt.After(now) {
+			delete(s.idempotency, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhooks := make([]Webhook, 0, len(s.webhooks))
+	for _, w := range s.webhooks {
+		w.Secret = ""
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+func (s *memoryStore) GetWebhook(ctx context.Context, id int) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range s.webhooks {
+		if w.ID == id {
+			return w, nil
+		}
+	}
+	return Webhook{}, errNotFound
+}
+
+func (s *memoryStore) CreateWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.ID = s.id()
+	w.CreatedAt = s.timestamp()
+	w.Events = slices.Clone(w.Events)
+	s.webhooks = append(s.webhooks, w)
+	return w, nil
+}
+
+func (s *memoryStore) DeleteWebhook(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.webhooks, func(w Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return errNotFound
+	}
+	s.webhooks = slices.Delete(s.webhooks, i, i+1)
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(d memoryDelivery) bool { return d.WebhookID == id })
+	return nil
+}
+
+func (s *memoryStore) CreateWebhookDelivery(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.ContainsFunc(s.webhooks, func(w Webhook) bool { return w.ID == d.WebhookID }) {
+		return WebhookDelivery{}, errNotFound
+	}
+	if slices.ContainsFunc(s.deliveries, func(other memoryDelivery) bool {
+		return other.WebhookID == d.WebhookID && other.EventID == d.EventID
+	}) {
+		return WebhookDelivery{}, errConflict
+	}
+	d.ID = s.id()
+	d.Status = deliveryPending
+	d.Attempts = 0
+	d.ResponseStatus, d.LastError, d.DeliveredAt = nil, nil, nil
+	d.CreatedAt = s.timestamp()
+	s.deliveries = append(s.deliveries, memoryDelivery{WebhookDelivery: d, createdAt: s.now()})
+	return d, nil
+}
+
+func (s *memoryStore) ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*memoryDelivery
+	for i := range s.deliveries {
+		d := &s.deliveries[i]
+		if d.Status == deliveryPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+
+	var claimed []WebhookDelivery
+	for _, d := range due[:min(limit, len(due))] {
+		d.NextAttemptAt = leaseUntil
+		claimed = append(claimed, d.WebhookDelivery)
+	}
+	return claimed, nil
+}
+
+func (s *memoryStore) RecordWebhookAttempt(ctx context.Context, d WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.deliveries {
+		stored := &s.deliveries[i]
+		if stored.ID != d.ID {
+			continue
+		}
+		stored.Status, stored.Attempts, stored.NextAttemptAt = d.Status, d.Attempts, d.NextAttemptAt
+		stored.ResponseStatus, stored.LastError = d.ResponseStatus, d.LastError
+		if d.Status == deliverySucceeded {
+			delivered := s.timestamp()
+			stored.DeliveredAt = &delivered
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) ListWebhookDeliveries(ctx context.Context, webhookID, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if s.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, s.deliveries[i].WebhookDelivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *memoryStore) PurgeWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.deliveries)
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(d memoryDelivery) bool {
+		return d.Status != deliveryPending && d.createdAt.Before(before)
+	})
+	return int64(n - len(s.deliveries)), nil
+}
+
+func (s *memoryStore) ListBudgets(ctx context.Context) ([]Budget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	budgets := make([]Budget, 0, len(s.budgets))
+	for _, b := range s.budgets {
+		budgets = append(budgets, s.withCategoryName(b))
+	}
+	sort.SliceStable(budgets, func(i, j int) bool {
+		if budgets[i].CategoryName != budgets[j].CategoryName {
+			return budgets[i].CategoryName < budgets[j].CategoryName
+		}
+		return budgets[i].StartDate < budgets[j].StartDate
+	})
+	return budgets, nil
+}
+
+func (s *memoryStore) withCategoryName(b Budget) Budget {
+	if c := s.categoryByID(&b.CategoryID); c != nil {
+		b.CategoryName = c.Name
+	}
+	return b
+}
+
+func (s *memoryStore) GetBudget(ctx context.Context, id int) (Budget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, b := range s.budgets {
+		if b.ID == id {
+			return s.withCategoryName(b), nil
+		}
+	}
+	return Budget{}, errNotFound
+}
+
+func (s *memoryStore) CreateBudget(ctx context.Context, b Budget, events func(Budget) []Event) (Budget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.categoryByID(&b.CategoryID) == nil {
+		return Budget{}, errInvalidReference
+	}
+	b.ID = s.id()
+	b.Version = 1
+	b.CreatedAt = s.timestamp()
+	s.budgets = append(s.budgets, b)
+	b = s.withCategoryName(b)
+	s.appendOutbox(events(b))
+	return b, nil
+}
+
+func (s *memoryStore) UpdateBudget(ctx context.Context, b Budget, expectedVersion int, events func(Budget) []Event) (Budget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.budgets {
+		cur := &s.budgets[i]
+		if cur.ID != b.ID {
+			continue
+		}
+		if expectedVersion != 0 && cur.Version != expectedVersion {
+			return Budget{}, errVersionMismatch
+		}
+		if s.categoryByID(&b.CategoryID) == nil {
+			return Budget{}, errInvalidReference
+		}
+		cur.CategoryID, cur.Amount, cur.Period = b.CategoryID, b.Amount, b.Period
+		cur.StartDate, cur.Rollover = b.StartDate, b.Rollover
+		cur.Version++
+		result := s.withCategoryName(*cur)
+		s.appendOutbox(events(result))
+		return result, nil
+	}
+	return Budget{}, errNotFound
+}
+
+func (s *memoryStore) DeleteBudget(ctx context.Context, id, expectedVersion int, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, b := range s.budgets {
+		if b.ID == id {
+			if expectedVersion != 0 && b.Version != expectedVersion {
+				return errVersionMismatch
+			}
+			s.budgets = slices.Delete(s.budgets, i, i+1)
+			s.notifications = slices.DeleteFunc(s.notifications, func(n memoryNotification) bool {
+				return n.BudgetID != nil && *n.BudgetID == id
+			})
+			s.appendOutbox(events)
+			return nil
+		}
+	}
+	if expectedVersion != 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (s *memoryStore) ListAllocations(ctx context.Context, through string) ([]Allocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var allocations []Allocation
+	for _, a := range s.allocations {
+		if a.Month <= through {
+			allocations = append(allocations, a)
+		}
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		if allocations[i].Month != allocations[j].Month {
+			return allocations[i].Month < allocations[j].Month
+		}
+		return allocations[i].CategoryID < allocations[j].CategoryID
+	})
+	return allocations, nil
+}
+
+func (s *memoryStore) SetAllocation(ctx context.Context, a Allocation, events func(Allocation) []Event) (Allocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.categoryByID(&a.CategoryID) == nil {
+		return Allocation{}, errInvalidReference
+	}
+	a.UpdatedAt = s.timestamp()
+	i := slices.IndexFunc(s.allocations, func(other Allocation) bool {
+		return other.Month == a.Month && other.CategoryID == a.CategoryID
+	})
+	if i >= 0 {
+		s.allocations[i] = a
+	} else {
+		s.allocations = append(s.allocations, a)
+	}
+	s.appendOutbox(events(a))
+	return a, nil
+}
+
+func (s *memoryStore) MonthlyTotals(ctx context.Context, through string) ([]MonthlyTotal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type key struct {
+		month      string
+		categoryID int // 0 when uncategorized
+		typ        string
+	}
+	sums := map[key]float64{}
+	for _, t := range s.transactions {
+		if len(t.Date) < 7 || t.Date[:7] > through {
+			continue
+		}
+		k := key{month: t.Date[:7], typ: t.Type}
+		if t.CategoryID != nil {
+			k.categoryID = *t.CategoryID
+		}
+		sums[k] += t.Amount
+	}
+
+	totals := make([]MonthlyTotal, 0, len(sums))
+	for k, sum := range sums {
+		total := MonthlyTotal{Month: k.month, Type: k.typ, Total: sum}
+		if k.categoryID != 0 {
+			id := k.categoryID
+			total.CategoryID = &id
+		}
+		totals = append(totals, total)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Month < totals[j].Month })
+	return totals, nil
+}
+
+func (s *memoryStore) BudgetUsage(ctx context.Context, categoryID int, month string) (BudgetUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.budgetUsage(categoryID, month); ok {
+		return u, nil
+	}
+	return BudgetUsage{}, errNotFound
+}
+
+func (s *memoryStore) ListBudgetUsage(ctx context.Context, month string) ([]BudgetUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var usage []BudgetUsage
+	seen := map[int]bool{}
+	for _, b := range s.budgets {
+		if seen[b.CategoryID] || b.StartDate[:7] > month {
+			continue
+		}
+		seen[b.CategoryID] = true
+		if u, ok := s.budgetUsage(b.CategoryID, month); ok {
+			usage = append(usage, u)
+		}
+	}
+	return usage, nil
+}
+
+// budgetUsage matches the SQL store's BudgetUsage: only budgets in effect
+// in month count. The caller holds s.mu.
+func (s *memoryStore) budgetUsage(categoryID int, month string) (BudgetUsage, bool) {
+	parents := categoryParents(s.categories)
+	root, ok := s.budgetRoot(categoryID, parents, month)
+	if !ok {
+		return BudgetUsage{}, false
+	}
+	var latest *Budget
+	for i := range s.budgets {
+		b := &s.budgets[i]
+		if b.CategoryID == root && b.StartDate[:7] <= month && (latest == nil || b.StartDate > latest.StartDate ||
+			b.StartDate == latest.StartDate && b.ID > latest.ID) {
+			latest = b
+		}
+	}
+	u := BudgetUsage{
+		BudgetID: latest.ID, CategoryID: root, CategoryName: s.withCategoryName(*latest).CategoryName,
+		Amount: latest.Amount, Period: latest.Period,
+	}
+	for _, t := range s.transactions {
+		if t.CategoryID == nil {
+			continue
+		}
+		if r, ok := s.budgetRoot(*t.CategoryID, parents, month); ok && r == root {
+			u.Spent += budgetSpending(t, month)
+		}
+	}
+	return u, true
+}
+
+// budgetRoot returns the nearest of categoryID and its ancestors that has a
+// budget in effect in month; the caller holds s.mu
+func (s *memoryStore) budgetRoot(categoryID int, parents map[int]*int, month string) (int, bool) {
+	for _, id := range categoryPath(categoryID, parents) {
+		for _, b := range s.budgets {
+			if b.CategoryID == id && b.StartDate[:7] <= month {
+				return id, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (s *memoryStore) ListGoals(ctx context.Context) ([]Goal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	goals := slices.Clone(s.goals)
+	sort.SliceStable(goals, func(i, j int) bool { return goals[i].TargetDate < goals[j].TargetDate })
+	if goals == nil {
+		goals = []Goal{}
+	}
+	return goals, nil
+}
+
+func (s *memoryStore) GetGoal(ctx context.Context, id int) (Goal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range s.goals {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+	return Goal{}, errNotFound
+}
+
+func (s *memoryStore) CreateGoal(ctx context.Context, g Goal, events func(Goal) []Event) (Goal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g.CategoryID != nil && s.categoryByID(g.CategoryID) == nil {
+		return Goal{}, errInvalidReference
+	}
+	g.ID = s.id()
+	g.Version = 1
+	g.CreatedAt = s.timestamp()
+	g.Progress = GoalProgress{}
+	s.goals = append(s.goals, g)
+	s.appendOutbox(events(g))
+	return g, nil
+}
+
+func (s *memoryStore) UpdateGoal(ctx context.Context, g Goal, expectedVersion int, events func(Goal) []Event) (Goal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.goals {
+		cur := &s.goals[i]
+		if cur.ID != g.ID {
+			continue
+		}
+		if expectedVersion != 0 && cur.Version != expectedVersion {
+			return Goal{}, errVersionMismatch
+		}
+		if g.CategoryID != nil && s.categoryByID(g.CategoryID) == nil {
+			return Goal{}, errInvalidReference
+		}
+		cur.Name, cur.TargetAmount, cur.TargetDate, cur.StartDate = g.Name, g.TargetAmount, g.TargetDate, g.StartDate
+		cur.CategoryID, cur.Tag = g.CategoryID, g.Tag
+		cur.Version++
+		s.appendOutbox(events(*cur))
+		return *cur, nil
+	}
+	return Goal{}, errNotFound
+}
+
+func (s *memoryStore) DeleteGoal(ctx context.Context, id, expectedVersion int, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, g := range s.goals {
+		if g.ID == id {
+			if expectedVersion != 0 && g.Version != expectedVersion {
+				return errVersionMismatch
+			}
+			s.goals = slices.Delete(s.goals, i, i+1)
+			s.appendOutbox(events)
+			return nil
+		}
+	}
+	if expectedVersion != 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (s *memoryStore) GoalsSaved(ctx context.Context, through string, goalID int) (map[int]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parents := categoryParents(s.categories)
+	saved := map[int]float64{}
+	for _, g := range s.goals {
+		if goalID != 0 && g.ID != goalID {
+			continue
+		}
+		adds := "income"
+		if c := s.categoryByID(g.CategoryID); c != nil {
+			adds = c.Type
+		}
+		for _, t := range s.transactions {
+			if len(t.Date) < 10 || t.Date[:10] < g.StartDate[:10] || t.Date[:10] > through {
+				continue
+			}
+			counts := false
+			switch {
+			case g.CategoryID != nil:
+				counts = t.CategoryID != nil && slices.Contains(categoryPath(*t.CategoryID, parents), *g.CategoryID)
+			case g.Tag != nil:
+				counts = hasTag(t.Description, *g.Tag) || t.Notes != nil && hasTag(*t.Notes, *g.Tag)
+			}
+			if !counts {
+				continue
+			}
+			if t.Type == adds {
+				saved[g.ID] += t.Amount
+			} else {
+				saved[g.ID] -= t.Amount
+			}
+		}
+	}
+	return saved, nil
+}
+
+func (s *memoryStore) CreateBudgetAlert(ctx context.Context, n Notification, events func(Notification) []Event) (Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.notifications {
+		if equalPtr(other.BudgetID, n.BudgetID) && equalPtr(other.PeriodStart, n.PeriodStart) &&
+			other.Threshold != nil && n.Threshold != nil && *other.Threshold >= *n.Threshold {
+			return Notification{}, errConflict
+		}
+	}
+	n.ID = s.id()
+	n.CreatedAt = s.timestamp()
+	n.ReadAt = nil
+	s.notifications = append(s.notifications, memoryNotification{Notification: n, readAt: map[int]string{}})
+	s.appendOutbox(events(n))
+	return n, nil
+}
+
+// forMember returns n with the read state of memberID
+func (n memoryNotification) forMember(memberID int) Notification {
+	result := n.Notification
+	if readAt, ok := n.readAt[memberID]; ok {
+		result.ReadAt = &readAt
+	}
+	return result
+}
+
+func (s *memoryStore) ListNotifications(ctx context.Context, memberID int, unreadOnly bool, limit int) ([]Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notifications := make([]Notification, 0)
+	for i := len(s.notifications) - 1; i >= 0 && len(notifications) < limit; i-- {
+		n := s.notifications[i].forMember(memberID)
+		if !unreadOnly || n.ReadAt == nil {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications, nil
+}
+
+func (s *memoryStore) CountUnreadNotifications(ctx context.Context, memberID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unread := 0
+	for _, n := range s.notifications {
+		if _, ok := n.readAt[memberID]; !ok {
+			unread++
+		}
+	}
+	return unread, nil
+}
+
+func (s *memoryStore) MarkNotificationRead(ctx context.Context, memberID, id int) (Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.notifications {
+		if n.ID != id {
+			continue
+		}
+		if _, ok := n.readAt[memberID]; !ok {
+			n.readAt[memberID] = s.timestamp()
+		}
+		return n.forMember(memberID), nil
+	}
+	return Notification{}, errNotFound
+}
+
+func (s *memoryStore) MarkAllNotificationsRead(ctx context.Context, memberID int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var marked int64
+	for _, n := range s.notifications {
+		if _, ok := n.readAt[memberID]; !ok {
+			n.readAt[memberID] = s.timestamp()
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// appendOutbox records events; the caller holds s.mu
+func (s *memoryStore) appendOutbox(events []Event) {
+	for _, e := range events {
+		s.outbox = append(s.outbox, memoryOutboxEntry{
+			OutboxEntry:   OutboxEntry{ID: int64(s.id()), Event: e},
+			nextAttemptAt: e.Time,
+		})
+	}
+}
+
+func (s *memoryStore) AppendOutbox(ctx context.Context, events ...Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appendOutbox(events)
+	return nil
+}
+
+func (s *memoryStore) ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var claimed []OutboxEntry
+	blocked := map[string]bool{}
+	for i := range s.outbox {
+		entry := &s.outbox[i]
+		if entry.publishedAt != nil || blocked[entry.Event.Aggregate] {
+			continue
+		}
+		blocked[entry.Event.Aggregate] = true
+		if len(claimed) < limit && !entry.nextAttemptAt.After(now) {
+			entry.nextAttemptAt = leaseUntil
+			claimed = append(claimed, entry.OutboxEntry)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *memoryStore) MarkOutboxPublished(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.outbox {
+		if s.outbox[i].ID == id {
+			published := s.now()
+			s.outbox[i].publishedAt = &published
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RetryOutbox(ctx context.Context, id int64, attempts int, next time.Time, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.outbox {
+		if s.outbox[i].ID == id {
+			s.outbox[i].Attempts, s.outbox[i].nextAttemptAt = attempts, next
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) PurgeOutbox(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.outbox)
+	s.outbox = slices.DeleteFunc(s.outbox, func(entry memoryOutboxEntry) bool {
+		return entry.publishedAt != nil && entry.publishedAt.Before(before)
+	})
+	return int64(n - len(s.outbox)), nil
+}
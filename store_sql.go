@@ -0,0 +1,1318 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sqlStore implements Store on top of a PostgreSQL or SQLite database
+type sqlStore struct {
+	db      timedDB
+	dialect dialect
+}
+
+// newSQLStore wraps db; statements slower than slowQuery are logged
+func newSQLStore(db *sql.DB, d dialect, slowQuery time.Duration) *sqlStore {
+	return &sqlStore{db: timedDB{DB: db, slow: slowQuery}, dialect: d}
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) SchemaVersion(ctx context.Context) (int, int, error) {
+	latest, err := latestMigrationVersion(s.dialect)
+	if err != nil {
+		return 0, 0, err
+	}
+	var applied int
+	err = s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&applied)
+	return applied, latest, err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// inTx runs fn with a copy of s whose statements all run in one transaction,
+// which is committed when fn succeeds
+func (s *sqlStore) inTx(ctx context.Context, fn func(s *sqlStore) error) error {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txStore := *s
+	txStore.db.tx = tx
+	if err := fn(&txStore); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) ListTransactions(ctx context.Context, limit int) ([]Transaction, error) {
+	query := `
+		SELECT t.id, t.date, t.description, t.amount, t.category_id, t.type, t.notes, t.created_by, t.version, t.created_at,
+		       c.name as category_name, c.color as category_color
+		FROM transactions t
+		LEFT JOIN categories c ON t.category_id = c.id
+		ORDER BY t.date DESC
+		LIMIT $1
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// ensure empty array ([]) instead of null when no rows
+	transactions := make([]Transaction, 0)
+	for rows.Next() {
+		var t Transaction
+		err := rows.Scan(
+			&t.ID, &t.Date, &t.Description, &t.Amount, &t.CategoryID, &t.Type, &t.Notes, &t.CreatedBy, &t.Version, &t.CreatedAt,
+			&t.CategoryName, &t.CategoryColor,
+		)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+func (s *sqlStore) GetTransaction(ctx context.Context, id int) (Transaction, error) {
+	query := `
+		SELECT t.id, t.date, t.description, t.amount, t.category_id, t.type, t.notes, t.created_by, t.version, t.created_at,
+		       c.name as category_name, c.color as category_color
+		FROM transactions t
+		LEFT JOIN categories c ON t.category_id = c.id
+		WHERE t.id = $1
+	`
+
+	var t Transaction
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&t.ID, &t.Date, &t.Description, &t.Amount, &t.CategoryID, &t.Type, &t.Notes, &t.CreatedBy, &t.Version, &t.CreatedAt,
+		&t.CategoryName, &t.CategoryColor,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Transaction{}, errNotFound
+	}
+	return t, err
+}
+
+func (s *sqlStore) CreateTransaction(ctx context.Context, t Transaction, events func(Transaction) []Event) (Transaction, error) {
+	query := `
+		INSERT INTO transactions (date, description, amount, category_id, type, notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, date, description, amount, category_id, type, notes, created_by, version, created_at
+	`
+
+	var result Transaction
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		err := s.db.QueryRowContext(ctx,
+			query, t.Date, t.Description, t.Amount, t.CategoryID, t.Type, t.Notes, t.CreatedBy,
+		).Scan(
+			&result.ID, &result.Date, &result.Description, &result.Amount,
+			&result.CategoryID, &result.Type, &result.Notes, &result.CreatedBy, &result.Version, &result.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) UpdateTransaction(ctx context.Context, t Transaction, expectedVersion int, events func(Transaction) []Event) (Transaction, error) {
+	var result Transaction
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE transactions
+			SET date = $2, description = $3, amount = $4, category_id = $5, type = $6, notes = $7, version = version + 1
+			WHERE id = $1 AND ($8 = 0 OR version = $8)
+		`, t.ID, t.Date, t.Description, t.Amount, t.CategoryID, t.Type, t.Notes, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return s.writeMissed(ctx, "transactions", t.ID)
+		}
+		if result, err = s.GetTransaction(ctx, t.ID); err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) DeleteTransaction(ctx context.Context, id, expectedVersion int, events []Event) error {
+	return s.inTx(ctx, func(s *sqlStore) error {
+		res, err := s.db.ExecContext(ctx,
+			"DELETE FROM transactions WHERE id = $1 AND ($2 = 0 OR version = $2)", id, expectedVersion,
+		)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 && expectedVersion != 0 {
+			return s.writeMissed(ctx, "transactions", id)
+		}
+		if n == 0 {
+			return nil
+		}
+		return s.appendOutbox(ctx, events)
+	})
+}
+
+// writeMissed explains why a conditional write to table matched no row:
+// either the row is gone or its version moved on
+func (s *sqlStore) writeMissed(ctx context.Context, table string, id int) error {
+	var version int
+	err := s.db.QueryRowContext(ctx, "SELECT version FROM "+table+" WHERE id = $1", id).Scan(&version)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return errNotFound
+	case err != nil:
+		return err
+	default:
+		return errVersionMismatch
+	}
+}
+
+const categoryColumns = "id, name, type, color, parent_id, version, created_at"
+
+func scanCategory(row interface{ Scan(...any) error }, c *Category) error {
+	return row.Scan(&c.ID, &c.Name, &c.Type, &c.Color, &c.ParentID, &c.Version, &c.CreatedAt)
+}
+
+func (s *sqlStore) ListCategories(ctx context.Context) ([]Category, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+categoryColumns+" FROM categories ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var cat Category
+		if err := scanCategory(rows, &cat); err != nil {
+			return nil, err
+		}
+		categories = append(categories, cat)
+	}
+	return categories, rows.Err()
+}
+
+func (s *sqlStore) GetCategory(ctx context.Context, id int) (Category, error) {
+	var cat Category
+	err := scanCategory(s.db.QueryRowContext(ctx, "SELECT "+categoryColumns+" FROM categories WHERE id = $1", id), &cat)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Category{}, errNotFound
+	}
+	return cat, err
+}
+
+func (s *sqlStore) CreateCategory(ctx context.Context, c Category, events func(Category) []Event) (Category, error) {
+	var result Category
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		var id int
+		err := s.db.QueryRowContext(ctx,
+			"INSERT INTO categories (name, type, color, parent_id) VALUES ($1, $2, $3, $4) RETURNING id",
+			c.Name, c.Type, c.Color, c.ParentID,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if result, err = s.GetCategory(ctx, id); err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) UpdateCategory(ctx context.Context, c Category, expectedVersion int, events func(Category) []Event) (Category, error) {
+	var result Category
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		if c.ParentID != nil {
+			if err := s.checkCategoryParent(ctx, c.ID, *c.ParentID); err != nil {
+				return err
+			}
+		}
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE categories SET name = $2, type = $3, color = $4, parent_id = $5, version = version + 1
+			WHERE id = $1 AND ($6 = 0 OR version = $6)
+		`, c.ID, c.Name, c.Type, c.Color, c.ParentID, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return s.writeMissed(ctx, "categories", c.ID)
+		}
+		if result, err = s.GetCategory(ctx, c.ID); err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+// checkCategoryParent returns errCategoryCycle when parentID is id or one of
+// its subcategories
+func (s *sqlStore) checkCategoryParent(ctx context.Context, id, parentID int) error {
+	if s.dialect.lockCategoriesSQL != "" {
+		if _, err := s.db.ExecContext(ctx, s.dialect.lockCategoriesSQL); err != nil {
+			return err
+		}
+	}
+	var cycle bool
+	err := s.db.QueryRowContext(ctx, `
+		WITH RECURSIVE ancestors(id) AS (
+			SELECT CAST($1 AS INTEGER)
+			UNION
+			SELECT c.parent_id FROM categories c JOIN ancestors a ON c.id = a.id WHERE c.parent_id IS NOT NULL
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)
+	`, parentID, id).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return errCategoryCycle
+	}
+	return nil
+}
+
+// categoryTreeCTE maps every category reachable from a root to its ancestor
+// at level $1 of the tree, or to itself when it is higher up or $1 is 0:
+// category_tree(id, top, level)
+const categoryTreeCTE = `
+	WITH RECURSIVE category_tree(id, top, level) AS (
+		SELECT id, id, 1 FROM categories WHERE parent_id IS NULL
+		UNION ALL
+		SELECT c.id, CASE WHEN $1 = 0 OR ct.level < $1 THEN c.id ELSE ct.top END, ct.level + 1
+		FROM categories c JOIN category_tree ct ON c.parent_id = ct.id
+	)`
+
+func (s *sqlStore) Analytics(ctx context.Context, depth int) (Analytics, error) {
+	// Query summary
+	since := s.dialect.daysAgo(30)
+	summaryQuery := `
+		SELECT
+			COALESCE(SUM(CASE WHEN type = 'income' THEN amount ELSE 0 END), 0) as total_income,
+			COALESCE(SUM(CASE WHEN type = 'expense' THEN amount ELSE 0 END), 0) as total_expenses,
+			COUNT(*) as transaction_count
+		FROM transactions
+		WHERE date >= ` + since
+
+	var summary AnalyticsSummary
+	err := s.db.QueryRowContext(ctx, summaryQuery).Scan(
+		&summary.TotalIncome, &summary.TotalExpenses, &summary.TransactionCount,
+	)
+	if err != nil {
+		return Analytics{}, err
+	}
+
+	// Query by category, rolling subcategories below depth up
+	categoryQuery := categoryTreeCTE + `
+		SELECT c.id, c.name, c.color, c.parent_id, COALESCE(SUM(t.amount), 0) as total
+		FROM transactions t
+		JOIN category_tree ct ON ct.id = t.category_id
+		JOIN categories c ON c.id = ct.top
+		WHERE t.date >= ` + since + ` AND t.type = 'expense'
+		GROUP BY c.id, c.name, c.color, c.parent_id
+		ORDER BY total DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, categoryQuery, depth)
+	if err != nil {
+		return Analytics{}, err
+	}
+	defer rows.Close()
+
+	// ensure empty array ([]) instead of null when no rows
+	byCategory := make([]CategoryAnalytics, 0)
+	for rows.Next() {
+		var cat CategoryAnalytics
+		if err := rows.Scan(&cat.ID, &cat.Name, &cat.Color, &cat.ParentID, &cat.Total); err != nil {
+			return Analytics{}, err
+		}
+		byCategory = append(byCategory, cat)
+	}
+	if err := rows.Err(); err != nil {
+		return Analytics{}, err
+	}
+
+	return Analytics{
+		Summary:    summary,
+		ByCategory: byCategory,
+	}, nil
+}
+
+func (s *sqlStore) MemberByTokenHash(ctx context.Context, tokenHash string) (Member, error) {
+	var m Member
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, email, role, created_at FROM members WHERE token_hash = $1", tokenHash,
+	).Scan(&m.ID, &m.Name, &m.Email, &m.Role, &m.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Member{}, errNotFound
+	}
+	return m, err
+}
+
+func (s *sqlStore) ListMembers(ctx context.Context) ([]Member, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, email, role, created_at FROM members ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]Member, 0)
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.ID, &m.Name, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (s *sqlStore) CreateMember(ctx context.Context, m Member, tokenHash string) (Member, error) {
+	var result Member
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO members (name, email, role, token_hash)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, name, email, role, created_at
+	`, m.Name, m.Email, m.Role, tokenHash).Scan(&result.ID, &result.Name, &result.Email, &result.Role, &result.CreatedAt)
+	return result, err
+}
+
+func (s *sqlStore) UpdateMemberRole(ctx context.Context, id int, role Role) (Member, error) {
+	var m Member
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		if role != RoleOwner {
+			if err := s.keepOwner(ctx, id); err != nil {
+				return err
+			}
+		}
+		err := s.db.QueryRowContext(ctx,
+			"UPDATE members SET role = $1 WHERE id = $2 RETURNING id, name, email, role, created_at",
+			role, id,
+		).Scan(&m.ID, &m.Name, &m.Email, &m.Role, &m.CreatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNotFound
+		}
+		return err
+	})
+	return m, err
+}
+
+func (s *sqlStore) DeleteMember(ctx context.Context, id int) error {
+	return s.inTx(ctx, func(s *sqlStore) error {
+		if err := s.keepOwner(ctx, id); err != nil {
+			return err
+		}
+		_, err := s.db.ExecContext(ctx, "DELETE FROM members WHERE id = $1", id)
+		return err
+	})
+}
+
+// keepOwner returns errLastOwner when member id is the only owner. It holds
+// the member lock until the transaction ends, so that two owners demoting
+// each other cannot both pass the check.
+func (s *sqlStore) keepOwner(ctx context.Context, id int) error {
+	if s.dialect.lockMembersSQL != "" {
+		if _, err := s.db.ExecContext(ctx, s.dialect.lockMembersSQL); err != nil {
+			return err
+		}
+	}
+	var owners, isOwner int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(CASE WHEN id = $1 THEN 1 END)
+		FROM members WHERE role = $2
+	`, id, RoleOwner).Scan(&owners, &isOwner)
+	if err != nil {
+		return err
+	}
+	if isOwner == 1 && owners == 1 {
+		return errLastOwner
+	}
+	return nil
+}
+
+func (s *sqlStore) ListInvitations(ctx context.Context) ([]Invitation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, email, role, invited_by, expires_at, accepted_at, created_at
+		FROM invitations ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := make([]Invitation, 0)
+	for rows.Next() {
+		var inv Invitation
+		err := rows.Scan(&inv.ID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *sqlStore) CreateInvitation(ctx context.Context, inv Invitation, tokenHash string, expiresAt time.Time) (Invitation, error) {
+	var result Invitation
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO invitations (email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, email, role, invited_by, expires_at, accepted_at, created_at
+	`, inv.Email, inv.Role, tokenHash, inv.InvitedBy, expiresAt.UTC()).Scan(
+		&result.ID, &result.Email, &result.Role, &result.InvitedBy, &result.ExpiresAt, &result.AcceptedAt, &result.CreatedAt,
+	)
+	return result, err
+}
+
+func (s *sqlStore) DeletePendingInvitation(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM invitations WHERE id = $1 AND accepted_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) AcceptInvitation(ctx context.Context, tokenHash, name, memberTokenHash string) (Member, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Member{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var inv Invitation
+	err = tx.QueryRowContext(ctx, `
+		UPDATE invitations SET accepted_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING email, role
+	`, tokenHash).Scan(&inv.Email, &inv.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Member{}, errNotFound
+	}
+	if err != nil {
+		return Member{}, err
+	}
+
+	var m Member
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO members (name, email, role, token_hash)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, name, email, role, created_at
+	`, name, inv.Email, inv.Role, memberTokenHash).Scan(&m.ID, &m.Name, &m.Email, &m.Role, &m.CreatedAt)
+	if err != nil {
+		return Member{}, err
+	}
+
+	return m, tx.Commit()
+}
+
+func (s *sqlStore) ReserveIdempotencyKey(ctx context.Context, memberID int, key string, rec IdempotencyRecord, now, staleBefore time.Time) (IdempotencyRecord, bool, error) {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE member_id = $1 AND idempotency_key = $2
+			AND (expires_at <= $3 OR (status_code IS NULL AND created_at < $4))
+	`, memberID, key, now.UTC(), staleBefore.UTC())
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (member_id, idempotency_key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (member_id, idempotency_key) DO NOTHING
+	`, memberID, key, rec.RequestHash, now.UTC(), rec.ExpiresAt.UTC())
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return rec, true, nil
+	}
+
+	var existing IdempotencyRecord
+	var status sql.NullInt64
+	err = s.db.QueryRowContext(ctx, `
+		SELECT request_hash, status_code, response_body, expires_at
+		FROM idempotency_keys WHERE member_id = $1 AND idempotency_key = $2
+	`, memberID, key).Scan(&existing.RequestHash, &status, &existing.Body, &existing.ExpiresAt)
+	existing.StatusCode = int(status.Int64)
+	return existing, false, err
+}
+
+func (s *sqlStore) CompleteIdempotencyKey(ctx context.Context, memberID int, key string, statusCode int, body []byte) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status_code = $3, response_body = $4
+		WHERE member_id = $1 AND idempotency_key = $2
+	`, memberID, key, statusCode, body)
+	return err
+}
+
+func (s *sqlStore) ReleaseIdempotencyKey(ctx context.Context, memberID int, key string) error {
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE member_id = $1 AND idempotency_key = $2 AND status_code IS NULL",
+		memberID, key,
+	)
+	return err
+}
+
+func (s *sqlStore) PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// webhookColumns lists the columns scanned by scanWebhook, without the secret
+const webhookColumns = "id, url, events, created_by, created_at"
+
+func scanWebhook(row interface{ Scan(...any) error }, w *Webhook, extra ...any) error {
+	var events string
+	if err := row.Scan(append([]any{&w.ID, &w.URL, &events, &w.CreatedBy, &w.CreatedAt}, extra...)...); err != nil {
+		return err
+	}
+	w.Events = strings.Split(events, ",")
+	return nil
+}
+
+func (s *sqlStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]Webhook, 0)
+	for rows.Next() {
+		var w Webhook
+		if err := scanWebhook(rows, &w); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *sqlStore) GetWebhook(ctx context.Context, id int) (Webhook, error) {
+	var w Webhook
+	err := scanWebhook(s.db.QueryRowContext(ctx,
+		"SELECT "+webhookColumns+", secret FROM webhooks WHERE id = $1", id,
+	), &w, &w.Secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, errNotFound
+	}
+	return w, err
+}
+
+func (s *sqlStore) CreateWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	var result Webhook
+	err := scanWebhook(s.db.QueryRowContext(ctx, `
+		INSERT INTO webhooks (url, secret, events, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+webhookColumns+`, secret
+	`, w.URL, w.Secret, strings.Join(w.Events, ","), w.CreatedBy), &result, &result.Secret)
+	return result, err
+}
+
+func (s *sqlStore) DeleteWebhook(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// deliveryColumns lists the columns scanned by scanDelivery
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts,
+	next_attempt_at, response_status, last_error, created_at, delivered_at`
+
+func scanDelivery(row interface{ Scan(...any) error }, d *WebhookDelivery) error {
+	var payload string
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	d.Payload = json.RawMessage(payload)
+	return err
+}
+
+func (s *sqlStore) CreateWebhookDelivery(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error) {
+	var result WebhookDelivery
+	err := scanDelivery(s.db.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING
+		RETURNING `+deliveryColumns,
+		d.WebhookID, d.EventID, d.EventType, string(d.Payload), deliveryPending, d.NextAttemptAt.UTC(),
+	), &result)
+	switch {
+	case isForeignKeyViolation(err):
+		return WebhookDelivery{}, errNotFound
+	case errors.Is(err, sql.ErrNoRows):
+		return WebhookDelivery{}, errConflict
+	}
+	return result, err
+}
+
+func (s *sqlStore) ClaimWebhookDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY next_attempt_at LIMIT $4 `+s.dialect.skipLocked+`
+		)
+		RETURNING `+deliveryColumns,
+		leaseUntil.UTC(), deliveryPending, now.UTC(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *sqlStore) RecordWebhookAttempt(ctx context.Context, d WebhookDelivery) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, response_status = $5, last_error = $6,
+			delivered_at = CASE WHEN $2 = 'succeeded' THEN CURRENT_TIMESTAMP END
+		WHERE id = $1
+	`, d.ID, d.Status, d.Attempts, d.NextAttemptAt.UTC(), d.ResponseStatus, d.LastError)
+	return err
+}
+
+func (s *sqlStore) ListWebhookDeliveries(ctx context.Context, webhookID, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2",
+		webhookID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *sqlStore) PurgeWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		"DELETE FROM webhook_deliveries WHERE status <> $1 AND created_at < $2",
+		deliveryPending, before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+const budgetColumns = "b.id, b.category_id, c.name, b.amount, COALESCE(b.period, 'monthly'), b.start_date, b.rollover, b.version, b.created_at"
+
+const budgetTables = " FROM budgets b JOIN categories c ON c.id = b.category_id"
+
+func scanBudget(row interface{ Scan(...any) error }, b *Budget) error {
+	return row.Scan(&b.ID, &b.CategoryID, &b.CategoryName, &b.Amount, &b.Period, &b.StartDate, &b.Rollover, &b.Version, &b.CreatedAt)
+}
+
+func (s *sqlStore) ListBudgets(ctx context.Context) ([]Budget, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+budgetColumns+budgetTables+" ORDER BY c.name, b.start_date, b.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	budgets := make([]Budget, 0)
+	for rows.Next() {
+		var b Budget
+		if err := scanBudget(rows, &b); err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
+
+func (s *sqlStore) GetBudget(ctx context.Context, id int) (Budget, error) {
+	var b Budget
+	err := scanBudget(s.db.QueryRowContext(ctx, "SELECT "+budgetColumns+budgetTables+" WHERE b.id = $1", id), &b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Budget{}, errNotFound
+	}
+	return b, err
+}
+
+func (s *sqlStore) CreateBudget(ctx context.Context, b Budget, events func(Budget) []Event) (Budget, error) {
+	var result Budget
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		var id int
+		err := s.db.QueryRowContext(ctx, `
+			INSERT INTO budgets (category_id, amount, period, start_date, rollover)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`, b.CategoryID, b.Amount, b.Period, b.StartDate, b.Rollover).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if result, err = s.GetBudget(ctx, id); err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) UpdateBudget(ctx context.Context, b Budget, expectedVersion int, events func(Budget) []Event) (Budget, error) {
+	var result Budget
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE budgets
+			SET category_id = $2, amount = $3, period = $4, start_date = $5, rollover = $6, version = version + 1
+			WHERE id = $1 AND ($7 = 0 OR version = $7)
+		`, b.ID, b.CategoryID, b.Amount, b.Period, b.StartDate, b.Rollover, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return s.writeMissed(ctx, "budgets", b.ID)
+		}
+		if result, err = s.GetBudget(ctx, b.ID); err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) DeleteBudget(ctx context.Context, id, expectedVersion int, events []Event) error {
+	return s.inTx(ctx, func(s *sqlStore) error {
+		res, err := s.db.ExecContext(ctx,
+			"DELETE FROM budgets WHERE id = $1 AND ($2 = 0 OR version = $2)", id, expectedVersion,
+		)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 && expectedVersion != 0 {
+			return s.writeMissed(ctx, "budgets", id)
+		}
+		if n == 0 {
+			return nil
+		}
+		return s.appendOutbox(ctx, events)
+	})
+}
+
+const allocationColumns = "month, category_id, amount, updated_by, updated_at"
+
+func scanAllocation(row interface{ Scan(...any) error }, a *Allocation) error {
+	return row.Scan(&a.Month, &a.CategoryID, &a.Amount, &a.UpdatedBy, &a.UpdatedAt)
+}
+
+func (s *sqlStore) ListAllocations(ctx context.Context, through string) ([]Allocation, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+allocationColumns+" FROM budget_allocations WHERE month <= $1 ORDER BY month, category_id", through,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allocations []Allocation
+	for rows.Next() {
+		var a Allocation
+		if err := scanAllocation(rows, &a); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
+
+func (s *sqlStore) SetAllocation(ctx context.Context, a Allocation, events func(Allocation) []Event) (Allocation, error) {
+	var result Allocation
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		err := scanAllocation(s.db.QueryRowContext(ctx, `
+			INSERT INTO budget_allocations (month, category_id, amount, updated_by)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (month, category_id) DO UPDATE
+			SET amount = excluded.amount, updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP
+			RETURNING `+allocationColumns,
+			a.Month, a.CategoryID, a.Amount, a.UpdatedBy,
+		), &result)
+		if err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) MonthlyTotals(ctx context.Context, through string) ([]MonthlyTotal, error) {
+	month := s.dialect.monthOf("date")
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+month+`, category_id, type, SUM(amount)
+		FROM transactions
+		WHERE `+month+` <= $1
+		GROUP BY 1, 2, 3
+		ORDER BY 1
+	`, through)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []MonthlyTotal
+	for rows.Next() {
+		var t MonthlyTotal
+		if err := rows.Scan(&t.Month, &t.CategoryID, &t.Type, &t.Total); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// budgetStarted is the condition that the budget aliased as alias applies
+// in the month passed as $1, as in budgetInEffect: from the month of its
+// start date on
+func (s *sqlStore) budgetStarted(alias string) string {
+	return s.dialect.monthOf(alias+".start_date") + " <= $1"
+}
+
+// budgetScopeCTE maps every category with a budget in effect to the
+// categories whose spending counts towards it: itself and the subcategories
+// below it that have no budget of their own. budget_scope(root, id)
+func (s *sqlStore) budgetScopeCTE() string {
+	return `
+	WITH RECURSIVE budget_scope(root, id) AS (
+		SELECT b.category_id, b.category_id FROM budgets b WHERE ` + s.budgetStarted("b") + `
+		UNION
+		SELECT bs.root, c.id FROM budget_scope bs JOIN categories c ON c.parent_id = bs.id
+		WHERE NOT EXISTS (SELECT 1 FROM budgets ob WHERE ob.category_id = c.id AND ` + s.budgetStarted("ob") + `)
+	)`
+}
+
+// budgetUsageQuery selects the budget in effect in the month passed as $1
+// for each category, with that month's spending, subcategories included. A
+// budget starting in a later month does not replace the current one until
+// then.
+func (s *sqlStore) budgetUsageQuery() string {
+	return s.budgetScopeCTE() + `
+		SELECT b.id, b.category_id, c.name, b.amount, COALESCE(b.period, 'monthly'), COALESCE((
+			SELECT SUM(t.amount) FROM transactions t JOIN budget_scope bs ON bs.id = t.category_id
+			WHERE bs.root = b.category_id AND t.type = 'expense' AND ` + s.dialect.monthOf("t.date") + ` = $1
+		), 0)
+		FROM budgets b JOIN categories c ON c.id = b.category_id
+		WHERE ` + s.budgetStarted("b") + ` AND NOT EXISTS (
+			SELECT 1 FROM budgets nb
+			WHERE nb.category_id = b.category_id AND ` + s.budgetStarted("nb") + `
+				AND (nb.start_date > b.start_date OR (nb.start_date = b.start_date AND nb.id > b.id))
+		)`
+}
+
+func scanBudgetUsage(row interface{ Scan(...any) error }, u *BudgetUsage) error {
+	return row.Scan(&u.BudgetID, &u.CategoryID, &u.CategoryName, &u.Amount, &u.Period, &u.Spent)
+}
+
+func (s *sqlStore) BudgetUsage(ctx context.Context, categoryID int, month string) (BudgetUsage, error) {
+	var u BudgetUsage
+	err := scanBudgetUsage(s.db.QueryRowContext(ctx, s.budgetUsageQuery()+" AND b.category_id IN (SELECT root FROM budget_scope WHERE id = $2)", month, categoryID), &u)
+	if errors.Is(err, sql.ErrNoRows) {
+		return BudgetUsage{}, errNotFound
+	}
+	return u, err
+}
+
+func (s *sqlStore) ListBudgetUsage(ctx context.Context, month string) ([]BudgetUsage, error) {
+	rows, err := s.db.QueryContext(ctx, s.budgetUsageQuery()+" ORDER BY b.id", month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []BudgetUsage
+	for rows.Next() {
+		var u BudgetUsage
+		if err := scanBudgetUsage(rows, &u); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+const notificationColumns = "id, type, message, budget_id, category_id, threshold, amount, spent, period_start, created_at"
+
+func scanNotification(row interface{ Scan(...any) error }, n *Notification) error {
+	return row.Scan(&n.ID, &n.Type, &n.Message, &n.BudgetID, &n.CategoryID, &n.Threshold,
+		&n.Amount, &n.Spent, &n.PeriodStart, &n.CreatedAt, &n.ReadAt)
+}
+
+func (s *sqlStore) CreateBudgetAlert(ctx context.Context, n Notification, events func(Notification) []Event) (Notification, error) {
+	var result Notification
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		var alerted bool
+		err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM notifications WHERE budget_id = $1 AND period_start = $2 AND threshold >= $3)
+		`, n.BudgetID, n.PeriodStart, n.Threshold).Scan(&alerted)
+		if err != nil {
+			return err
+		}
+		if alerted {
+			return errConflict
+		}
+		err = scanNotification(s.db.QueryRowContext(ctx, `
+			INSERT INTO notifications (type, message, budget_id, category_id, threshold, amount, spent, period_start)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING `+notificationColumns+`, NULL`,
+			n.Type, n.Message, n.BudgetID, n.CategoryID, n.Threshold, n.Amount, n.Spent, n.PeriodStart,
+		), &result)
+		if isUniqueViolation(err) {
+			return errConflict
+		}
+		if err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) ListNotifications(ctx context.Context, memberID int, unreadOnly bool, limit int) ([]Notification, error) {
+	query := `
+		SELECT ` + notificationColumns + `, r.read_at
+		FROM notifications n
+		LEFT JOIN notification_reads r ON r.notification_id = n.id AND r.member_id = $1`
+	if unreadOnly {
+		query += " WHERE r.read_at IS NULL"
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY n.id DESC LIMIT $2", memberID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := make([]Notification, 0)
+	for rows.Next() {
+		var n Notification
+		if err := scanNotification(rows, &n); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (s *sqlStore) CountUnreadNotifications(ctx context.Context, memberID int) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM notifications n
+		WHERE NOT EXISTS (SELECT 1 FROM notification_reads r WHERE r.notification_id = n.id AND r.member_id = $1)
+	`, memberID).Scan(&n)
+	return n, err
+}
+
+func (s *sqlStore) MarkNotificationRead(ctx context.Context, memberID, id int) (Notification, error) {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO notification_reads (notification_id, member_id, read_at) VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (notification_id, member_id) DO NOTHING
+	`, id, memberID)
+	if isForeignKeyViolation(err) {
+		return Notification{}, errNotFound
+	}
+	if err != nil {
+		return Notification{}, err
+	}
+
+	var n Notification
+	err = scanNotification(s.db.QueryRowContext(ctx, `
+		SELECT `+notificationColumns+`, r.read_at
+		FROM notifications n
+		LEFT JOIN notification_reads r ON r.notification_id = n.id AND r.member_id = $1
+		WHERE n.id = $2
+	`, memberID, id), &n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Notification{}, errNotFound
+	}
+	return n, err
+}
+
+func (s *sqlStore) MarkAllNotificationsRead(ctx context.Context, memberID int) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO notification_reads (notification_id, member_id, read_at)
+		SELECT n.id, $1, CURRENT_TIMESTAMP FROM notifications n
+		WHERE NOT EXISTS (SELECT 1 FROM notification_reads r WHERE r.notification_id = n.id AND r.member_id = $1)
+	`, memberID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// appendOutbox records events in the outbox; within inTx they commit or roll
+// back with the change they describe
+func (s *sqlStore) appendOutbox(ctx context.Context, events []Event) error {
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO outbox (aggregate, event_id, event_type, payload, next_attempt_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, e.Aggregate, e.ID, e.Type, string(payload), e.Time.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) AppendOutbox(ctx context.Context, events ...Event) error {
+	return s.inTx(ctx, func(s *sqlStore) error {
+		return s.appendOutbox(ctx, events)
+	})
+}
+
+func (s *sqlStore) ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE outbox SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT o.id FROM outbox o
+			WHERE o.published_at IS NULL AND o.next_attempt_at <= $2 AND NOT EXISTS (
+				SELECT 1 FROM outbox p
+				WHERE p.aggregate = o.aggregate AND p.published_at IS NULL AND p.id < o.id
+			)
+			ORDER BY o.id LIMIT $3 `+s.dialect.skipLocked+`
+		)
+		RETURNING id, payload, attempts
+	`, leaseUntil.UTC(), now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var (
+			entry   OutboxEntry
+			payload string
+		)
+		if err := rows.Scan(&entry.ID, &payload, &entry.Attempts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(payload), &entry.Event); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b OutboxEntry) int { return cmp.Compare(a.ID, b.ID) })
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) MarkOutboxPublished(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE outbox SET published_at = CURRENT_TIMESTAMP, last_error = NULL WHERE id = $1", id)
+	return err
+}
+
+func (s *sqlStore) RetryOutbox(ctx context.Context, id int64, attempts int, next time.Time, lastErr string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE outbox SET attempts = $2, next_attempt_at = $3, last_error = $4 WHERE id = $1",
+		id, attempts, next.UTC(), lastErr,
+	)
+	return err
+}
+
+func (s *sqlStore) PurgeOutbox(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		"DELETE FROM outbox WHERE published_at IS NOT NULL AND published_at < $1", before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+const goalColumns = "id, name, target_amount, target_date, start_date, category_id, tag, created_by, version, created_at"
+
+func scanGoal(row interface{ Scan(...any) error }, g *Goal) error {
+	return row.Scan(&g.ID, &g.Name, &g.TargetAmount, &g.TargetDate, &g.StartDate, &g.CategoryID, &g.Tag, &g.CreatedBy, &g.Version, &g.CreatedAt)
+}
+
+func (s *sqlStore) ListGoals(ctx context.Context) ([]Goal, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+goalColumns+" FROM goals ORDER BY target_date, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := make([]Goal, 0)
+	for rows.Next() {
+		var g Goal
+		if err := scanGoal(rows, &g); err != nil {
+			return nil, err
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+func (s *sqlStore) GetGoal(ctx context.Context, id int) (Goal, error) {
+	var g Goal
+	err := scanGoal(s.db.QueryRowContext(ctx, "SELECT "+goalColumns+" FROM goals WHERE id = $1", id), &g)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Goal{}, errNotFound
+	}
+	return g, err
+}
+
+func (s *sqlStore) CreateGoal(ctx context.Context, g Goal, events func(Goal) []Event) (Goal, error) {
+	var result Goal
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		var id int
+		err := s.db.QueryRowContext(ctx, `
+			INSERT INTO goals (name, target_amount, target_date, start_date, category_id, tag, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id
+		`, g.Name, g.TargetAmount, g.TargetDate, g.StartDate, g.CategoryID, g.Tag, g.CreatedBy).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if result, err = s.GetGoal(ctx, id); err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) UpdateGoal(ctx context.Context, g Goal, expectedVersion int, events func(Goal) []Event) (Goal, error) {
+	var result Goal
+	err := s.inTx(ctx, func(s *sqlStore) error {
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE goals
+			SET name = $2, target_amount = $3, target_date = $4, start_date = $5, category_id = $6, tag = $7,
+				version = version + 1
+			WHERE id = $1 AND ($8 = 0 OR version = $8)
+		`, g.ID, g.Name, g.TargetAmount, g.TargetDate, g.StartDate, g.CategoryID, g.Tag, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return s.writeMissed(ctx, "goals", g.ID)
+		}
+		if result, err = s.GetGoal(ctx, g.ID); err != nil {
+			return err
+		}
+		return s.appendOutbox(ctx, events(result))
+	})
+	return result, err
+}
+
+func (s *sqlStore) DeleteGoal(ctx context.Context, id, expectedVersion int, events []Event) error {
+	return s.inTx(ctx, func(s *sqlStore) error {
+		res, err := s.db.ExecContext(ctx,
+			"DELETE FROM goals WHERE id = $1 AND ($2 = 0 OR version = $2)", id, expectedVersion,
+		)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 && expectedVersion != 0 {
+			return s.writeMissed(ctx, "goals", id)
+		}
+		if n == 0 {
+			return nil
+		}
+		return s.appendOutbox(ctx, events)
+	})
+}
+
+// goalsSavedQuery totals category goals in the database, one row per goal
+// with no tag, and returns the candidate transactions of tag goals one row
+// each: LIKE finds them, and hasTag then rules out longer tags that start
+// with the goal's. Transactions of the goal category's type add to a
+// category goal. $2 narrows it to one goal unless it is 0.
+const goalsSavedQuery = `
+	WITH RECURSIVE goal_categories(goal_id, id) AS (
+		SELECT id, category_id FROM goals WHERE category_id IS NOT NULL AND ($2 = 0 OR id = $2)
+		UNION
+		SELECT gc.goal_id, c.id FROM goal_categories gc JOIN categories c ON c.parent_id = gc.id
+	)
+	SELECT g.id, NULL, SUM(CASE WHEN t.type = gcat.type THEN t.amount ELSE -t.amount END), '', ''
+	FROM goals g
+	JOIN categories gcat ON gcat.id = g.category_id
+	JOIN goal_categories gc ON gc.goal_id = g.id
+	JOIN transactions t ON t.category_id = gc.id
+	WHERE t.date >= g.start_date AND t.date <= $1
+	GROUP BY g.id
+	UNION ALL
+	SELECT g.id, g.tag, CASE WHEN t.type = 'income' THEN t.amount ELSE -t.amount END,
+		t.description, COALESCE(t.notes, '')
+	FROM goals g
+	JOIN transactions t ON LOWER(t.description) LIKE '%#' || g.tag || '%' OR LOWER(t.notes) LIKE '%#' || g.tag || '%'
+	WHERE g.tag IS NOT NULL AND ($2 = 0 OR g.id = $2) AND t.date >= g.start_date AND t.date <= $1`
+
+func (s *sqlStore) GoalsSaved(ctx context.Context, through string, goalID int) (map[int]float64, error) {
+	rows, err := s.db.QueryContext(ctx, goalsSavedQuery, through, goalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	saved := map[int]float64{}
+	for rows.Next() {
+		var id int
+		var tag sql.NullString
+		var amount float64
+		var description, notes string
+		if err := rows.Scan(&id, &tag, &amount, &description, &notes); err != nil {
+			return nil, err
+		}
+		if !tag.Valid || hasTag(description, tag.String) || hasTag(notes, tag.String) {
+			saved[id] += amount
+		}
+	}
+	return saved, rows.Err()
+}
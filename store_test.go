@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eachStore runs test against the memory store and a fresh SQLite store
+func eachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) { test(t, newMemoryStore()) })
+	t.Run("sqlite", func(t *testing.T) {
+		cfg := defaultConfig()
+		cfg.DatabaseURL = "sqlite://" + filepath.Join(t.TempDir(), "test.db")
+		store, err := openStore(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		test(t, store)
+	})
+}
+
+func TestBudgetUsageIgnoresBudgetsNotYetStarted(t *testing.T) {
+	now := time.Now().UTC()
+	thisMonth := now.Format("2006-01") + "-01"
+	midMonth := now.Format("2006-01") + "-15"
+	nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)
+	month := now.Format("2006-01")
+
+	eachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		create := func(b Budget) Budget {
+			t.Helper()
+			b, err := store.CreateBudget(ctx, b, noEvents[Budget])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+		groceries := create(Budget{CategoryID: 1, Amount: 300, Period: "monthly", StartDate: thisMonth})
+		create(Budget{CategoryID: 1, Amount: 500, Period: "monthly", StartDate: nextMonth})
+		// Starting mid-month applies to the whole month, as in the ledger
+		rent := create(Budget{CategoryID: 2, Amount: 900, Period: "monthly", StartDate: midMonth})
+		// Only starting next month: no usage yet
+		create(Budget{CategoryID: 3, Amount: 100, Period: "monthly", StartDate: nextMonth})
+
+		u, err := store.BudgetUsage(ctx, 1, month)
+		if err != nil || u.BudgetID != groceries.ID || u.Amount != 300 {
+			t.Errorf("groceries usage = %+v, %v; want the budget of this month", u, err)
+		}
+		if u, err := store.BudgetUsage(ctx, 2, month); err != nil || u.BudgetID != rent.ID {
+			t.Errorf("rent usage = %+v, %v; want the mid-month budget", u, err)
+		}
+		if _, err := store.BudgetUsage(ctx, 3, month); err != errNotFound {
+			t.Errorf("utilities usage error = %v, want not found", err)
+		}
+
+		usage, err := store.ListBudgetUsage(ctx, month)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(usage) != 2 || usage[0].BudgetID != groceries.ID || usage[1].BudgetID != rent.ID {
+			t.Errorf("usage = %+v, want groceries and rent as of this month", usage)
+		}
+		if usage, _ := store.ListBudgetUsage(ctx, nextMonth[:7]); len(usage) != 3 {
+			t.Errorf("next month's usage = %+v, want the budgets starting then too", usage)
+		}
+	})
+}
+
+func TestBudgetUsageCountsSpendingOfTheMonth(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		if _, err := store.CreateBudget(ctx, Budget{CategoryID: 1, Amount: 100, Period: "monthly", StartDate: "2026-03-01"}, noEvents[Budget]); err != nil {
+			t.Fatal(err)
+		}
+		groceries := 1
+		for _, tx := range []Transaction{
+			{Date: "2026-03-31", Description: "Market", Amount: 30, Type: "expense", CategoryID: &groceries},
+			{Date: "2026-04-01", Description: "Market", Amount: 50, Type: "expense", CategoryID: &groceries},
+			{Date: "2026-05-01", Description: "Market", Amount: 20, Type: "expense", CategoryID: &groceries},
+		} {
+			if _, err := store.CreateTransaction(ctx, tx, noEvents[Transaction]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for month, want := range map[string]float64{"2026-03": 30, "2026-04": 50, "2026-05": 20} {
+			if u, err := store.BudgetUsage(ctx, 1, month); err != nil || u.Spent != want {
+				t.Errorf("%s usage = %+v, %v; want %v spent", month, u, err, want)
+			}
+		}
+		if _, err := store.BudgetUsage(ctx, 1, "2026-02"); err != errNotFound {
+			t.Errorf("usage before the budget started: %v, want not found", err)
+		}
+	})
+}
+
+func TestGoalsSavedNetsIncomeAndExpenses(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		salary := 6
+		bonus, err := store.CreateCategory(ctx, Category{Name: "Bonus", Type: "income", Color: "#00aa00", ParentID: &salary}, noEvents[Category])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fund, err := store.CreateGoal(ctx, Goal{Name: "Fund", TargetAmount: 5000, StartDate: "2026-01-10", TargetDate: "2026-12-31", CategoryID: &salary}, noEvents[Goal])
+		if err != nil {
+			t.Fatal(err)
+		}
+		transfers, err := store.CreateCategory(ctx, Category{Name: "Savings transfers", Type: "expense", Color: "#0000aa"}, noEvents[Category])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Transfers to savings are booked as expenses and add to the goal
+		emergency, err := store.CreateGoal(ctx, Goal{Name: "Emergency", TargetAmount: 3000, StartDate: "2026-01-10", TargetDate: "2026-12-31", CategoryID: &transfers.ID}, noEvents[Goal])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag := "trip"
+		trip, err := store.CreateGoal(ctx, Goal{Name: "Trip", TargetAmount: 1000, StartDate: "2026-01-10", TargetDate: "2026-12-31", Tag: &tag}, noEvents[Goal])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		note := "paid for #trip snacks"
+		for _, tx := range []Transaction{
+			{Date: "2026-02-01", Description: "Salary", Amount: 1000, Type: "income", CategoryID: &salary},
+			{Date: "2026-03-01", Description: "Bonus", Amount: 200, Type: "income", CategoryID: &bonus.ID},
+			{Date: "2026-03-02", Description: "Withdrawal", Amount: 50, Type: "expense", CategoryID: &salary},
+			{Date: "2026-01-09", Description: "Before the start", Amount: 999, Type: "income", CategoryID: &salary},
+			{Date: "2026-07-01", Description: "After through", Amount: 999, Type: "income", CategoryID: &salary},
+			{Date: "2026-02-01", Description: "Side gig #trip", Amount: 300, Type: "income"},
+			{Date: "2026-04-01", Description: "Flights #Trip", Amount: 100, Type: "expense"},
+			{Date: "2026-04-02", Description: "Snacks", Amount: 20, Type: "expense", Notes: &note},
+			{Date: "2026-04-03", Description: "Other #trips", Amount: 77, Type: "income"},
+			{Date: "2026-02-05", Description: "To savings", Amount: 400, Type: "expense", CategoryID: &transfers.ID},
+			{Date: "2026-03-05", Description: "From savings", Amount: 50, Type: "income", CategoryID: &transfers.ID},
+		} {
+			if _, err := store.CreateTransaction(ctx, tx, noEvents[Transaction]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		saved, err := store.GoalsSaved(ctx, "2026-06-30", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved[fund.ID] != 1150 {
+			t.Errorf("saved towards the category goal = %v, want 1150", saved[fund.ID])
+		}
+		if saved[trip.ID] != 180 {
+			t.Errorf("saved towards the tag goal = %v, want 180", saved[trip.ID])
+		}
+		if saved[emergency.ID] != 350 {
+			t.Errorf("saved towards the expense category goal = %v, want 350", saved[emergency.ID])
+		}
+
+		for _, id := range []int{fund.ID, trip.ID, emergency.ID} {
+			one, err := store.GoalsSaved(ctx, "2026-06-30", id)
+			if err != nil || len(one) != 1 || one[id] != saved[id] {
+				t.Errorf("goal %d alone = %v, %v; want only %v", id, one, err, saved[id])
+			}
+		}
+	})
+}
+
+func TestHouseholdKeepsAnOwner(t *testing.T) {
+	eachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		for _, name := range []string{"Alex", "Sam"} {
+			if _, err := store.CreateMember(ctx, Member{Name: name, Role: RoleOwner}, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		members, err := store.ListMembers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Every owner steps down at once; one of them has to stay
+		var wg sync.WaitGroup
+		errs := make(chan error, len(members))
+		for _, m := range members {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := store.UpdateMemberRole(ctx, m.ID, RoleEditor)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		refused := 0
+		for err := range errs {
+			if errors.Is(err, errLastOwner) {
+				refused++
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if refused != 1 {
+			t.Fatalf("%d of %d demotions refused, want 1", refused, len(members))
+		}
+
+		members, _ = store.ListMembers(ctx)
+		var owner Member
+		for _, m := range members {
+			if m.Role == RoleOwner {
+				owner = m
+			}
+		}
+		if err := store.DeleteMember(ctx, owner.ID); !errors.Is(err, errLastOwner) {
+			t.Errorf("removing the last owner: %v, want errLastOwner", err)
+		}
+		if _, err := store.UpdateMemberRole(ctx, owner.ID, RoleOwner); err != nil {
+			t.Errorf("keeping the last owner an owner: %v", err)
+		}
+	})
+}
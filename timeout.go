@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamingRoutes stay open for as long as the client listens, so no
+// configured budget applies to them
+var streamingRoutes = map[string]bool{
+	"GET /api/events": true,
+}
+
+// requestTimeout bounds the request context by the timeout configured for
+// the route. Every store and cache call uses that context, so a slow query is
+// cancelled once the budget is spent, and also when the client disconnects.
+func (s *Server) requestTimeout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		d := s.cfg.Timeouts.forRoute(route)
+		if d <= 0 || streamingRoutes[route] {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestTimeoutExemptsEventStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{cfg: defaultConfig()}
+	// What REQUEST_ROUTE_TIMEOUTS leaves behind: the defaults are replaced
+	s.cfg.Timeouts.Routes = map[string]time.Duration{"GET /api/analytics": time.Second}
+
+	r := gin.New()
+	r.Use(s.requestTimeout())
+	deadlines := map[string]bool{}
+	record := func(c *gin.Context) {
+		_, deadlines[c.FullPath()] = c.Request.Context().Deadline()
+	}
+	r.GET("/api/events", record)
+	r.GET("/api/transactions", record)
+
+	for _, path := range []string{"/api/events", "/api/transactions"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if deadlines["/api/events"] {
+		t.Error("the event stream has a deadline")
+	}
+	if !deadlines["/api/transactions"] {
+		t.Error("the transaction list has no deadline")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// initTracing installs the global tracer provider and W3C trace context
+// propagation. With the "none" exporter the global provider stays a no-op, so
+// the HTTP, SQL and Redis instrumentation costs next to nothing. The returned
+// function flushes buffered spans and must be called on shutdown.
+func initTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		// Endpoint, headers and TLS come from the standard
+		// OTEL_EXPORTER_OTLP_* environment variables
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("building trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	slog.Info("Tracing enabled", "exporter", cfg.Exporter)
+	return tp.Shutdown, nil
+}
+
+// traceRequest reports whether an HTTP request should get a span. Probes and
+// metrics scrapes are polled constantly and would drown out real traffic.
+func traceRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/health", "/livez", "/readyz", "/metrics":
+		return false
+	}
+	return true
+}
+
+// sqlSpanOptions configures otelsql to emit one span per statement
+var sqlSpanOptions = otelsql.SpanOptions{
+	OmitConnResetSession: true,
+	OmitConnPrepare:      true,
+	OmitRows:             true,
+	DisableErrSkip:       true,
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers sent with every webhook delivery
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// eventWebhookTest is only sent by the test-fire endpoint
+const eventWebhookTest = "webhook.test"
+
+const (
+	// webhookBatchSize is how many due deliveries are attempted at once
+	webhookBatchSize = 20
+	// webhookLeaseMargin is added to the attempt timeout when a delivery is
+	// claimed, so that it is only retried elsewhere once this replica must
+	// have given up on it
+	webhookLeaseMargin = 30 * time.Second
+	// webhookDrainLimit is how much of a response is read and discarded so
+	// that its connection can be reused
+	webhookDrainLimit = 4096
+)
+
+// errPrivateAddress refuses a delivery to an internal address
+var errPrivateAddress = errors.New("address is not public")
+
+// internalPrefixes are the ranges refused on top of what netip classifies
+// as loopback, private, link-local, multicast and unspecified
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+}
+
+// webhookPayload is the JSON body of a delivery
+type webhookPayload struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// signWebhook returns the X-Webhook-Signature value of body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">". The
+// timestamp is signed too, so receivers can reject replayed deliveries.
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookDispatcher delivers queued webhook deliveries. The queue lives in
+// the store, so deliveries survive restarts, and replicas claim deliveries
+// before attempting them, so each attempt is made by one replica only.
+type webhookDispatcher struct {
+	store   WebhookStore
+	cfg     WebhooksConfig
+	client  *http.Client
+	metrics *metrics
+	wake    chan struct{}
+}
+
+func newWebhookDispatcher(store WebhookStore, cfg WebhooksConfig, m *metrics) *webhookDispatcher {
+	return &webhookDispatcher{
+		store:   store,
+		cfg:     cfg,
+		client:  newWebhookClient(cfg),
+		metrics: m,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// newWebhookClient returns the client deliveries are posted with. It never
+// follows redirects, and unless cfg.AllowPrivateNetworks is set it refuses
+// to connect to internal addresses. The check runs on the resolved address
+// of every connection, so DNS cannot smuggle an internal host past it.
+func newWebhookClient(cfg WebhooksConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = refuseInternalAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would connect to the receiver on our behalf, past the check
+	transport.Proxy = nil
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// refuseInternalAddress is a net.Dialer Control hook that fails connections
+// to anything but public unicast addresses
+func refuseInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("%s: %w", ip, errPrivateAddress)
+	}
+	for _, p := range internalPrefixes {
+		if p.Contains(ip) {
+			return fmt.Errorf("%s: %w", ip, errPrivateAddress)
+		}
+	}
+	return nil
+}
+
+// enqueue queues e for every webhook subscribed to its type
+func (d *webhookDispatcher) enqueue(ctx context.Context, e Event) error {
+	webhooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(webhookPayload{ID: e.ID, Type: e.Type, CreatedAt: e.Time, Data: e.Data})
+	if err != nil {
+		return err
+	}
+	queued := false
+	for _, w := range webhooks {
+		if !slices.Contains(w.Events, e.Type) {
+			continue
+		}
+		_, err := d.store.CreateWebhookDelivery(ctx, WebhookDelivery{
+			WebhookID: w.ID, EventID: e.ID, EventType: e.Type, Payload: payload, NextAttemptAt: time.Now(),
+		})
+		// errConflict: the event was relayed before and is already queued
+		if err != nil && !errors.Is(err, errNotFound) && !errors.Is(err, errConflict) {
+			return err
+		}
+		queued = true
+	}
+	if queued {
+		d.nudge()
+	}
+	return nil
+}
+
+// nudge makes run look at the queue now rather than at the next poll
+func (d *webhookDispatcher) nudge() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run attempts due deliveries until ctx ends
+func (d *webhookDispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		for d.dispatchDue(ctx) == webhookBatchSize {
+			// A full batch: there may be more due right away
+		}
+	}
+}
+
+// dispatchDue attempts one batch of due deliveries and returns its size
+func (d *webhookDispatcher) dispatchDue(ctx context.Context) int {
+	now := time.Now()
+	due, err := d.store.ClaimWebhookDeliveries(ctx, now, now.Add(d.cfg.Timeout+webhookLeaseMargin), webhookBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("Claiming webhook deliveries failed", "error", err)
+		}
+		return 0
+	}
+
+	webhooks := map[int]*Webhook{}
+	var wg sync.WaitGroup
+	for _, delivery := range due {
+		w, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			found, err := d.store.GetWebhook(ctx, delivery.WebhookID)
+			if err != nil {
+				// A deleted webhook takes its deliveries with it; anything
+				// else is retried once the lease runs out
+				if !errors.Is(err, errNotFound) {
+					slog.Warn("Loading webhook failed", "webhook_id", delivery.WebhookID, "error", err)
+				}
+				continue
+			}
+			w = &found
+			webhooks[delivery.WebhookID] = w
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.attempt(context.WithoutCancel(ctx), *w, delivery)
+		}()
+	}
+	wg.Wait()
+	return len(due)
+}
+
+// attempt posts a delivery to its webhook and records the outcome. Failed
+// deliveries are retried with exponential backoff until cfg.MaxAttempts.
+func (d *webhookDispatcher) attempt(ctx context.Context, w Webhook, delivery WebhookDelivery) WebhookDelivery {
+	delivery.Attempts++
+	status, err := d.post(ctx, w, delivery)
+	if status != 0 {
+		delivery.ResponseStatus = &status
+	}
+
+	outcome := "retrying"
+	switch {
+	case err == nil:
+		delivered := time.Now().UTC().Format(time.RFC3339)
+		delivery.Status, delivery.LastError, delivery.DeliveredAt = deliverySucceeded, nil, &delivered
+		outcome = deliverySucceeded
+	case delivery.Attempts >= d.cfg.MaxAttempts:
+		msg := err.Error()
+		delivery.Status, delivery.LastError = deliveryFailed, &msg
+		outcome = deliveryFailed
+		slog.Warn("Giving up on webhook delivery", "webhook_id", w.ID, "delivery_id", delivery.ID,
+			"attempts", delivery.Attempts, "error", err)
+	default:
+		msg := err.Error()
+		delivery.Status, delivery.LastError = deliveryPending, &msg
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+	}
+	d.metrics.webhookDelivered(outcome)
+
+	if err := d.store.RecordWebhookAttempt(ctx, delivery); err != nil {
+		slog.Warn("Recording webhook attempt failed", "delivery_id", delivery.ID, "error", err)
+	}
+	return delivery
+}
+
+// backoff returns the wait after the given number of failed attempts
+func (d *webhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.RetryBackoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.cfg.MaxBackoff)
+}
+
+// post sends a delivery and returns the response status. Any status other
+// than 2xx is an error, which names the status only: the body is up to the
+// receiver and is not kept.
+func (d *webhookDispatcher) post(ctx context.Context, w Webhook, delivery WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "finance-dashboard-webhooks")
+	req.Header.Set(webhookEventHeader, delivery.EventType)
+	req.Header.Set(webhookDeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(webhookSignatureHeader, signWebhook(w.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookDrainLimit))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// purgeWebhookDeliveries deletes finished deliveries older than retention
+// every interval until ctx ends
+func purgeWebhookDeliveries(ctx context.Context, store WebhookStore, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.PurgeWebhookDeliveries(ctx, time.Now().Add(-retention))
+			if err != nil {
+				slog.Warn("Purging webhook deliveries failed", "error", err)
+			} else if n > 0 {
+				slog.Info("Purged old webhook deliveries", "count", n)
+			}
+		}
+	}
+}
+
+// listWebhooks retrieves all webhooks, without their secrets
+func (s *Server) listWebhooks(c *gin.Context) {
+	webhooks, err := s.store.ListWebhooks(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// createWebhook subscribes a URL to event types. The signing secret is only
+// returned in this response.
+func (s *Server) createWebhook(c *gin.Context) {
+	var w Webhook
+	if err := c.ShouldBindJSON(&w); err != nil {
+		respondError(c, bindError(err))
+		return
+	}
+	slices.Sort(w.Events)
+	w.Events = slices.Compact(w.Events)
+	if w.Secret == "" {
+		secret, _, err := newToken()
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		w.Secret = secret
+	}
+	w.CreatedBy = &currentMember(c).ID
+
+	result, err := s.store.CreateWebhook(c.Request.Context(), w)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// getWebhook retrieves one webhook, without its secret
+func (s *Server) getWebhook(c *gin.Context) {
+	w, ok := s.webhookParam(c)
+	if !ok {
+		return
+	}
+	w.Secret = ""
+
+	c.JSON(http.StatusOK, w)
+}
+
+// deleteWebhook removes a webhook and its delivery log
+func (s *Server) deleteWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid webhook id"))
+		return
+	}
+
+	err = s.store.DeleteWebhook(c.Request.Context(), id)
+	if errors.Is(err, errNotFound) {
+		respondError(c, notFound("webhook not found"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// listWebhookDeliveries retrieves a webhook's delivery log, newest first
+func (s *Server) listWebhookDeliveries(c *gin.Context) {
+	w, ok := s.webhookParam(c)
+	if !ok {
+		return
+	}
+	limit := 50
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			respondError(c, invalidField("limit", "must be between 1 and 500"))
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := s.store.ListWebhookDeliveries(c.Request.Context(), w.ID, limit)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// testWebhook sends a webhook.test event to a webhook straight away and
+// returns the logged delivery. A failed test is retried like any delivery.
+func (s *Server) testWebhook(c *gin.Context) {
+	w, ok := s.webhookParam(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	e := Event{ID: newRequestID(), Type: eventWebhookTest, Time: time.Now().UTC()}
+	e.Data, _ = json.Marshal(gin.H{"webhook_id": w.ID})
+	payload, err := json.Marshal(webhookPayload{ID: e.ID, Type: e.Type, CreatedAt: e.Time, Data: e.Data})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	// Leased from the start, so the dispatcher leaves it to this request
+	delivery, err := s.store.CreateWebhookDelivery(ctx, WebhookDelivery{
+		WebhookID: w.ID, EventID: e.ID, EventType: e.Type, Payload: payload,
+		NextAttemptAt: time.Now().Add(s.cfg.Webhooks.Timeout + webhookLeaseMargin),
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, s.webhooks.attempt(context.WithoutCancel(ctx), w, delivery))
+}
+
+// webhookParam loads the webhook named by the :id parameter, responding
+// with an error if there is none
+func (s *Server) webhookParam(c *gin.Context) (Webhook, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, badRequest("invalid webhook id"))
+		return Webhook{}, false
+	}
+	w, err := s.store.GetWebhook(c.Request.Context(), id)
+	if errors.Is(err, errNotFound) {
+		respondError(c, notFound("webhook not found"))
+		return Webhook{}, false
+	}
+	if err != nil {
+		respondError(c, err)
+		return Webhook{}, false
+	}
+	return w, true
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefuseInternalAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		refused bool
+	}{
+		{"127.0.0.1:80", true},
+		{"[::1]:80", true},
+		{"[::ffff:127.0.0.1]:80", true},
+		{"169.254.169.254:80", true},
+		{"[fe80::1]:80", true},
+		{"10.1.2.3:443", true},
+		{"172.16.0.1:443", true},
+		{"192.168.1.10:443", true},
+		{"[fd00::1]:443", true},
+		{"100.64.0.1:443", true},
+		{"0.0.0.0:80", true},
+		{"224.0.0.1:80", true},
+		{"93.184.216.34:443", false},
+		{"[2606:4700::1111]:443", false},
+	}
+	for _, tt := range tests {
+		err := refuseInternalAddress("tcp", tt.address, nil)
+		if refused := errors.Is(err, errPrivateAddress); refused != tt.refused {
+			t.Errorf("%s: refused = %v, want %v (%v)", tt.address, refused, tt.refused, err)
+		}
+	}
+}
+
+// webhookReceiver is an httptest receiver that answers with the statuses
+// given, one per request, and records what it was sent
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, receivedWebhook{at: time.Now(), header: req.Header, body: body})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "stack trace of an internal service")
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+// newTestDispatcher returns a dispatcher that may reach the loopback
+// receivers of these tests, retrying after 50ms, then 100ms
+func newTestDispatcher(store Store) *webhookDispatcher {
+	cfg := defaultConfig().Webhooks
+	cfg.AllowPrivateNetworks = true
+	cfg.RetryBackoff = 50 * time.Millisecond
+	cfg.MaxBackoff = 100 * time.Millisecond
+	cfg.MaxAttempts = 4
+	return newWebhookDispatcher(store, cfg, newMetrics(store))
+}
+
+func TestWebhookDeliveriesAreSignedAndRetried(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	d := newTestDispatcher(store)
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	secret := "0123456789abcdef0123"
+	w, err := store.CreateWebhook(ctx, Webhook{URL: receiver.URL, Events: []string{eventTransactionCreated}, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Event{ID: "evt-1", Type: eventTransactionCreated, Time: time.Now().UTC(), Data: []byte(`{"id":1}`)}
+	if err := d.enqueue(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first attempt fails and is scheduled after the first backoff,
+	// without keeping what the receiver answered
+	d.dispatchDue(ctx)
+	deliveries, err := store.ListWebhookDeliveries(ctx, w.ID, 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries = %+v, %v", deliveries, err)
+	}
+	first := deliveries[0]
+	if first.Status != deliveryPending || first.Attempts != 1 || first.ResponseStatus == nil || *first.ResponseStatus != 500 {
+		t.Errorf("after one attempt: %+v", first)
+	}
+	if first.LastError == nil || *first.LastError != "receiver answered 500" {
+		t.Errorf("last_error = %v, want the status only", first.LastError)
+	}
+	if wait := time.Until(first.NextAttemptAt); wait <= 0 || wait > 50*time.Millisecond {
+		t.Errorf("next attempt in %s, want within 50ms", wait)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(receiver.received()) < 3 && time.Now().Before(deadline) {
+		d.dispatchDue(ctx)
+		time.Sleep(5 * time.Millisecond)
+	}
+	got := receiver.received()
+	if len(got) != 3 {
+		t.Fatalf("receiver got %d deliveries, want 3", len(got))
+	}
+	for i, backoff := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond} {
+		if wait := got[i+1].at.Sub(got[i].at); wait < backoff {
+			t.Errorf("retry %d came after %s, want at least %s", i+1, wait, backoff)
+		}
+	}
+	for _, r := range got {
+		if r.header.Get(webhookEventHeader) != eventTransactionCreated {
+			t.Errorf("event header = %q", r.header.Get(webhookEventHeader))
+		}
+		sig := r.header.Get(webhookSignatureHeader)
+		ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			t.Fatalf("signature %q has no timestamp", sig)
+		}
+		if want := signWebhook(secret, time.Unix(unix, 0), r.body); sig != want {
+			t.Errorf("signature = %s, want %s", sig, want)
+		}
+	}
+
+	deliveries, _ = store.ListWebhookDeliveries(ctx, w.ID, 10)
+	if dl := deliveries[0]; dl.Status != deliverySucceeded || dl.Attempts != 3 || dl.LastError != nil {
+		t.Errorf("delivery = %+v, want succeeded after 3 attempts", dl)
+	}
+}
+
+func TestWebhookBackoffDoublesUpToMax(t *testing.T) {
+	d := newTestDispatcher(newMemoryStore())
+	for attempts, want := range map[int]time.Duration{1: 50 * time.Millisecond, 2: 100 * time.Millisecond, 5: 100 * time.Millisecond} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestWebhookClientRefusesInternalReceivers(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	d := newWebhookDispatcher(store, defaultConfig().Webhooks, newMetrics(store))
+	receiver := newWebhookReceiver(t)
+
+	delivery := d.attempt(ctx, Webhook{URL: receiver.URL, Secret: "s"}, WebhookDelivery{Payload: []byte(`{}`)})
+	if len(receiver.received()) != 0 {
+		t.Error("a loopback receiver was reached")
+	}
+	if delivery.LastError == nil || !strings.Contains(*delivery.LastError, errPrivateAddress.Error()) {
+		t.Errorf("last_error = %v, want the address refused", delivery.LastError)
+	}
+}
+
+func TestWebhookClientDoesNotFollowRedirects(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	d := newTestDispatcher(store)
+	target := newWebhookReceiver(t)
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+
+	delivery := d.attempt(ctx, Webhook{URL: redirect.URL, Secret: "s"}, WebhookDelivery{Payload: []byte(`{}`)})
+	if len(target.received()) != 0 {
+		t.Error("the redirect was followed")
+	}
+	if delivery.ResponseStatus == nil || *delivery.ResponseStatus != http.StatusTemporaryRedirect {
+		t.Errorf("response status = %v, want 307", delivery.ResponseStatus)
+	}
+}
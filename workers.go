@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// workers runs background goroutines that share one context, so they can
+// all be told to stop at shutdown and then waited for.
+type workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWorkers() *workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workers{ctx: ctx, cancel: cancel}
+}
+
+// Go starts fn in a goroutine. fn must return once ctx is cancelled.
+func (w *workers) Go(name string, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(w.ctx)
+		slog.Info("Background worker stopped", "worker", name)
+	}()
+}
+
+// Stop cancels the shared context and waits for every worker to return, or
+// for ctx to expire first.
+func (w *workers) Stop(ctx context.Context) error {
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}